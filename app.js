@@ -5,7 +5,8 @@
 let audioCtx = null;
 let micStream = null;
 let isRecording = false;
-let recordedChunks = [];
+let recordedChunks = []; // per block: one Float32Array per input channel
+let recordSampleRate = 44100;
 let selectedFile = null;
 let selectedFileName = '';
 let modulation = 'QPSK';
@@ -22,49 +23,122 @@ document.addEventListener('DOMContentLoaded', () => {
     document.getElementById('max-duration').addEventListener('change', () => {
         updateModulationInfo();
     });
+    document.getElementById('received-name').addEventListener('change', e => {
+        receivedNamePolicy = e.target.value === 'refuse' ? 'refuse' : 'rename';
+        addLog('info', `같은 이름의 수신 파일: ${receivedNamePolicy === 'refuse' ? '무시' : '번호를 붙여 저장'}`);
+    });
+    document.getElementById('sub-mask').addEventListener('change', e => {
+        setSubcarrierMask(parseInt(e.target.value, 16));
+        e.target.value = formatSubMask(OFDM.subMask);
+        addLog('info', `서브캐리어 마스크: 0x${formatSubMask(OFDM.subMask)}`);
+        updateModulationInfo();
+    });
+    document.getElementById('link-seed').addEventListener('change', e => {
+        setLinkSeed(parseInt(e.target.value, 10));
+        e.target.value = OFDM.linkSeed;
+        addLog('info', `링크 시드: ${OFDM.linkSeed} (송수신 양쪽이 같아야 합니다)`);
+    });
+    document.getElementById('preamble-repeats').addEventListener('change', e => {
+        setPreambleRepeats(parseInt(e.target.value, 10));
+        e.target.value = OFDM.preambleRepeats;
+        addLog('info', `프리앰블 반복: ${OFDM.preambleRepeats}회 (송수신 양쪽이 같아야 합니다)`);
+        updateModulationInfo();
+    });
+    document.getElementById('output-level').addEventListener('change', e => {
+        setOutputAmplitude(parseFloat(e.target.value));
+        addLog('info', `송신 음량: ${Math.round(OFDM.outputAmplitude * 100)}%`);
+    });
+    document.getElementById('input-gain').addEventListener('change', e => {
+        inputGainDb = parseFloat(e.target.value) || 0;
+        addLog('info', `입력 게인: +${inputGainDb} dB`);
+    });
+    document.getElementById('chunk-size').addEventListener('change', e => {
+        setChunkSize(e.target.value === 'auto' ? null : parseInt(e.target.value, 10));
+        addLog('info', `청크 크기: ${e.target.value === 'auto' ? '자동' : formatSize(OFDM.chunkSize)}`);
+    });
+    document.getElementById('file-hash').addEventListener('change', e => {
+        setFileHash(parseInt(e.target.value, 10));
+        addLog('info', `파일 해시: ${OFDM.fileHash ? FILE_HASH_NAMES[OFDM.fileHash] : '없음'}`);
+    });
+    document.getElementById('parity-group').addEventListener('change', e => {
+        setParityGroup(parseInt(e.target.value, 10));
+        addLog('info', `패리티 그룹: ${OFDM.parityGroup ? `청크 ${OFDM.parityGroup}개마다 1프레임` : '끔'}`);
+    });
+    document.getElementById('calibration-chirp').addEventListener('change', e => {
+        setCalibrationChirp(e.target.value === '1');
+        addLog('info', `보정 스윕: ${OFDM.calibrationChirp ? '첫 프레임 앞에 전송' : '끔'}`);
+    });
+    document.getElementById('log-level').addEventListener('change', e => {
+        logLevel = e.target.value;
+    });
+    // Closing the page mid-transfer cuts the frame off; ask first
+    window.addEventListener('beforeunload', e => {
+        if (isSending || isRecording || isStreamingReceive || isReplaying) {
+            e.preventDefault();
+            e.returnValue = '';
+        }
+    });
     updateModulationInfo();
 });
 
+function formatSubMask(mask) {
+    return mask.toString(16).toUpperCase().padStart(4, '0');
+}
+
+// Receivers can't reach the sender, so a weak-band suggestion is only logged
+function logSuggestedMask(mask) {
+    if (mask === undefined || mask === FULL_SUB_MASK) return;
+    addLog('warn', `약한 대역 감지 — 송신측 서브캐리어 마스크를 0x${formatSubMask(mask)}(으)로 설정해 보세요`);
+}
+
+// Echoes near or past the cyclic prefix smear symbols into each other
+function logReverbWarning(spread) {
+    addLog('warn', `잔향이 CP 한계에 가깝거나 넘습니다 (${formatDelaySpread(spread)}) — 기기를 가까이 두거나 CP가 긴 BPSK 음향 모드를 쓰세요`);
+}
+
 function getMaxDuration() {
     return parseInt(document.getElementById('max-duration').value) || 600;
 }
 
 function updateModulationInfo() {
+    const el = document.getElementById('modulation-info');
+    if (modulation === 'MFSK') {
+        el.innerHTML = `최대 전송: <strong style="color:#00d4ff">${formatSize(MFSK_MAX_BYTES)}</strong> · 속도: ~9 B/s (수동 수신만)`;
+        return;
+    }
     const MAX_DURATION = getMaxDuration();
     const HEADER_BYTES = 15; // nameLen(1) + name(~6) + dataLen(4) + CRC(4)
     const { config, modName, repetition } = getModemParams(modulation);
     const cfg = OFDM_CONFIGS[config];
 
-    // 데이터 서브캐리어 수 계산
+    // 데이터 서브캐리어 수 계산 (마스크로 꺼진 그룹 제외)
+    const pilots = cfg.PILOTS || generatePilots(cfg.SUB_START, cfg.SUB_END, cfg.NUM_PILOTS);
     let dataSubs = 0;
     for (let k = cfg.SUB_START; k <= cfg.SUB_END; k++) {
-        if (!cfg.PILOTS.includes(k)) dataSubs++;
+        if (!pilots.includes(k)) dataSubs++;
+    }
+    let activeSubs = 0;
+    for (let di = 0; di < dataSubs; di++) {
+        if ((OFDM.subMask >> Math.floor(di * SUB_GROUPS / dataSubs)) & 1) activeSubs++;
     }
 
     const bps = Constellations[modName].bps;
-    const bitsPerSymbol = dataSubs * bps;
+    const bitsPerSymbol = activeSubs * bps;
     const symDuration = cfg.SYMBOL_LEN / cfg.SAMPLE_RATE;
-    const isAcoustic = cfg.CP_LEN >= 128;
-    const overhead = (isAcoustic ? 1.0 : 0.5) + 3 * symDuration;
+    const headerSymbols = Math.ceil(FRAME_HEADER_COPIES * FRAME_HEADER_BITS / dataSubs);
+    const silence = frameGuardSeconds('lead', cfg) + frameGuardSeconds('trail', cfg);
+    const overhead = silence + (2 + OFDM.preambleRepeats + headerSymbols) * symDuration;
     const availTime = MAX_DURATION - overhead;
-    const maxSymbols = Math.floor(availTime / symDuration);
+    const syncShare = cfg.SYNC_INTERVAL > 0 ? cfg.SYNC_INTERVAL / (cfg.SYNC_INTERVAL + 1) : 1;
+    const maxSymbols = Math.floor(availTime / symDuration * syncShare);
     const maxBits = maxSymbols * bitsPerSymbol;
     const maxBytes = Math.floor(maxBits / 8 / repetition) - HEADER_BYTES;
     const speed = maxBytes / availTime;
 
-    const el = document.getElementById('modulation-info');
     const minutes = Math.round(MAX_DURATION / 60);
     el.innerHTML = `최대 수신: <strong style="color:#00d4ff">${formatSize(maxBytes)}</strong> (${minutes}분 녹음) · 속도: ~${formatSize(Math.round(speed))}/s`;
 }
 
-function getModemParams(mod) {
-    if (mod === 'BPSK-ACOUSTIC') return { config: 'acoustic', modName: 'BPSK', repetition: 1 };
-    if (mod === 'BPSK-REPEAT') return { config: 'acoustic', modName: 'BPSK', repetition: 3 };
-    if (mod === 'BPSK-NARROW') return { config: 'narrowband', modName: 'BPSK', repetition: 3 };
-    if (mod === '16-QAM') return { config: 'standard', modName: 'QAM16', repetition: 1 };
-    return { config: 'standard', modName: 'QPSK', repetition: 1 };
-}
-
 function getAudioContext() {
     if (!audioCtx || audioCtx.state === 'closed') {
         audioCtx = new (window.AudioContext || window.webkitAudioContext)({ sampleRate: 44100 });
@@ -73,6 +147,50 @@ function getAudioContext() {
     return audioCtx;
 }
 
+// ScriptProcessor callbacks arrive exactly one block apart in playbackTime;
+// a larger step means the main thread fell behind and input was dropped.
+// Returns a function mapping each event to the number of samples lost.
+function createDropoutDetector(ctx, blockSize) {
+    const blockTime = blockSize / ctx.sampleRate;
+    let nextTime = -1;
+    return (e) => {
+        const gap = nextTime < 0 ? 0 : e.playbackTime - nextTime;
+        nextTime = e.playbackTime + blockTime;
+        return gap > blockTime / 2 ? Math.round(gap * OFDM.SAMPLE_RATE) : 0;
+    };
+}
+
+// Software gain on every receive input, for a device whose mic or line gain
+// can't be raised enough: preamble detection ignores input below about
+// -54 dBFS. Like a hardware gain stage it clips at ±1.0, so too much gain
+// shows up in the receiver's clipping warning. Always returns a new array.
+let inputGainDb = 0;
+
+function applyInputGain(samples) {
+    const out = new Float32Array(samples);
+    if (inputGainDb === 0) return out;
+    const g = Math.pow(10, inputGainDb / 20);
+    for (let i = 0; i < out.length; i++) out[i] = Math.max(-1, Math.min(1, out[i] * g));
+    return out;
+}
+
+// The 44100 Hz request is only a hint; some browsers keep the hardware rate.
+// Returns null when no conversion is needed.
+function createInputResampler(ctx) {
+    if (ctx.sampleRate === OFDM.SAMPLE_RATE) return null;
+    addLog('info', `장치 샘플레이트 ${ctx.sampleRate} Hz → ${OFDM.SAMPLE_RATE} Hz 리샘플링`);
+    return new StreamResampler(ctx.sampleRate, OFDM.SAMPLE_RATE);
+}
+
+// A device running below twice the band's top frequency can't carry the
+// band at all, e.g. the ultrasonic one on a 16 kHz headset profile
+function checkDeviceBand(ctx) {
+    if (!bandConfigError(OFDM, ctx.sampleRate)) return true;
+    const topHz = Math.round(OFDM.SUB_END * OFDM.SAMPLE_RATE / OFDM.FFT_SIZE);
+    addLog('error', `장치 샘플레이트 ${ctx.sampleRate} Hz로는 ${topHz} Hz까지 쓰는 이 변조 방식을 낼 수 없습니다 — 다른 변조 방식을 고르세요`);
+    return false;
+}
+
 // --- Mode ---
 function setMode(mode) {
     document.getElementById('btn-send-mode').classList.toggle('active', mode === 'send');
@@ -99,6 +217,10 @@ function setReceiveMode(mode) {
 }
 
 function onReceiveClick() {
+    if (receiveMode === 'streaming' && modulation === 'MFSK' && !isStreamingReceive) {
+        addLog('warn', 'MFSK는 수동 (트림) 수신만 지원합니다');
+        return;
+    }
     if (receiveMode === 'streaming') {
         startStreamingReceive();
     } else {
@@ -114,23 +236,35 @@ function handleFileSelect(event) {
     selectedFileName = file.name;
     document.getElementById('file-info').textContent = `${file.name} (${formatSize(file.size)})`;
     document.getElementById('btn-send').disabled = false;
+    document.getElementById('btn-save-wav').disabled = false;
     addLog('info', `파일 선택: ${file.name} (${formatSize(file.size)})`);
 }
 
 // --- Send ---
-const CHUNK_THRESHOLD = 32 * 1024; // 32KB — 이 이하는 레거시, 이상은 청크
 let chunkedSendAbort = false;
+let chunkedSendPaused = false;
+let resumeChunkedSend = null; // resolves the wait of a paused send
+let isSending = false;
 
 async function startSend() {
     if (!selectedFile) return;
 
+    if (beaconRun) toggleBeacon();
     const { config, modName, repetition } = getModemParams(modulation);
     setOFDMConfig(config);
+    if (!checkDeviceBand(getAudioContext())) return;
 
-    if (selectedFile.size <= CHUNK_THRESHOLD) {
-        await startSendLegacy();
-    } else {
-        await playChunkedFrames();
+    isSending = true;
+    try {
+        if (modulation === 'MFSK') {
+            await startSendMFSK();
+        } else if (selectedFile.size <= CHUNK_THRESHOLD) {
+            await startSendLegacy();
+        } else {
+            await playChunkedFrames();
+        }
+    } finally {
+        isSending = false;
     }
 }
 
@@ -153,51 +287,63 @@ async function startSendLegacy() {
         const { config, modName, repetition } = getModemParams(modulation);
         setOFDMConfig(config);
         const result = buildTransmitSignal(fileData, modName, selectedFileName, repetition);
+        if (result.error) {
+            addLog('error', `변조 실패: ${result.error}`);
+            return;
+        }
 
-        const duration = result.signal.length / 44100;
+        const duration = result.signal.length / OFDM.SAMPLE_RATE;
         addLog('info', `변조 완료: ${result.numSymbols} 심볼, ${duration.toFixed(1)}초`);
         updateProgress(0.3, '오디오 재생 중...');
 
         const ctx = getAudioContext();
-        const buffer = ctx.createBuffer(1, result.signal.length, 44100);
-        buffer.getChannelData(0).set(result.signal);
-
-        const source = ctx.createBufferSource();
-        source.buffer = buffer;
-        source.connect(ctx.destination);
-
-        source.onended = () => {
-            updateProgress(1.0, '전송 완료!');
-            addLog('success', `전송 완료: ${selectedFileName} (${formatSize(fileData.length)})`);
-            btn.disabled = false;
-            btn.textContent = '전송 시작';
-        };
-
-        source.start();
-
         const startTime = ctx.currentTime;
         const progressInterval = setInterval(() => {
             const elapsed = ctx.currentTime - startTime;
             const progress = Math.min(0.3 + 0.7 * (elapsed / duration), 0.99);
             updateProgress(progress, `재생 중... ${elapsed.toFixed(1)}s / ${duration.toFixed(1)}s`);
-            if (elapsed >= duration) clearInterval(progressInterval);
         }, 200);
+        try {
+            await playSignalAsync(ctx, result.signal);
+        } finally {
+            clearInterval(progressInterval);
+        }
 
+        updateProgress(1.0, '전송 완료!');
+        addLog('success', `전송 완료: ${selectedFileName} (${formatSize(fileData.length)})`);
     } catch (err) {
         addLog('error', `전송 오류: ${err.message}`);
+    } finally {
         btn.disabled = false;
         btn.textContent = '전송 시작';
     }
 }
 
-// --- Chunked Send (대용량 파일, 더블 버퍼링) ---
-
-function getChunkSize(modName) {
-    if (modName === 'QAM16') return 4096;
-    if (modName === 'QPSK') return 2048;
-    return 512; // BPSK
+// MFSK fallback: one short message when OFDM won't get through
+async function startSendMFSK() {
+    const fileData = new Uint8Array(await selectedFile.arrayBuffer());
+    const signal = new MFSKModem().encode(fileData, selectedFileName);
+    if (signal.error) {
+        addLog('error', `MFSK는 ${formatSize(MFSK_MAX_BYTES)} 이하의 파일만 보낼 수 있습니다 (${formatSize(fileData.length)})`);
+        return;
+    }
+    const btn = document.getElementById('btn-send');
+    btn.disabled = true;
+    showProgress();
+    const duration = signal.length / OFDM.SAMPLE_RATE;
+    addLog('info', `MFSK 전송 시작: ${selectedFileName} (${formatSize(fileData.length)}, ${duration.toFixed(1)}초)`);
+    updateProgress(0.1, `MFSK 재생 중... ${duration.toFixed(1)}초`);
+    try {
+        await playSignalAsync(getAudioContext(), signal);
+        updateProgress(1.0, '전송 완료!');
+        addLog('success', `전송 완료: ${selectedFileName} (${formatSize(fileData.length)})`);
+    } finally {
+        btn.disabled = false;
+    }
 }
 
+// --- Chunked Send (대용량 파일, 더블 버퍼링) ---
+
 async function playChunkedFrames() {
     const btn = document.getElementById('btn-send');
     btn.disabled = true;
@@ -212,17 +358,24 @@ async function playChunkedFrames() {
 
     addLog('info', `청크 전송 시작: ${selectedFileName} (${formatSize(fileSize)}, ${totalChunks}개 청크, 각 ${formatSize(chunkSize)})`);
     showProgress();
-    updateChunkProgressUI(0, totalChunks, 0);
+    updateChunkProgressUI(0, totalChunks, 0, null);
 
     const ctx = getAudioContext();
-    const sendStartTime = Date.now();
+    let rateMeter = new RateMeter();
+    rateMeter.add(0);
 
     try {
-        // 1. 메타데이터 프레임 전송
+        // 1. 메타데이터 프레임 전송 (파일 해시는 파일을 한 번 읽어 먼저 계산)
+        let fileHash = null;
+        if (OFDM.fileHash) {
+            btn.textContent = '해시 계산 중...';
+            updateProgress(0, `${FILE_HASH_NAMES[OFDM.fileHash]} 계산 중...`);
+            fileHash = await hashFile(selectedFile, chunkSize, OFDM.fileHash);
+        }
         btn.textContent = '메타 전송 중...';
         updateProgress(0, '메타데이터 프레임 전송 중...');
 
-        const metaSignal = buildMetadataFrame(totalChunks, fileSize, chunkSize, selectedFileName, modName, repetition);
+        const metaSignal = buildMetadataFrame(totalChunks, fileSize, chunkSize, selectedFileName, modName, repetition, fileHash);
         await playSignalAsync(ctx, metaSignal);
 
         if (chunkedSendAbort) { finishChunkedSend(btn, '전송 중단됨'); return; }
@@ -230,21 +383,24 @@ async function playChunkedFrames() {
         // 2. 데이터 청크 순차 전송 (더블 버퍼링)
         btn.textContent = '전송 중지';
         btn.disabled = false;
-        btn.onclick = () => { chunkedSendAbort = true; };
+        btn.onclick = () => { chunkedSendAbort = true; setChunkedSendPaused(false); };
+        document.getElementById('btn-pause-send').style.display = 'block';
 
-        let nextFrameSignal = null; // 미리 빌드된 다음 프레임
+        let nextFrame = null; // 미리 빌드된 다음 프레임 { chunkData, signal }
+        const parityGroup = OFDM.parityGroup;
+        let parity = null, groupStart = 0;
 
         for (let seq = 0; seq < totalChunks; seq++) {
             if (chunkedSendAbort) break;
 
             // 현재 청크 프레임 준비 (더블 버퍼에서 가져오거나 새로 빌드)
-            let currentSignal;
-            if (nextFrameSignal) {
-                currentSignal = nextFrameSignal;
-                nextFrameSignal = null;
+            let current;
+            if (nextFrame) {
+                current = nextFrame;
+                nextFrame = null;
             } else {
                 const chunkData = await readFileChunk(selectedFile, seq, chunkSize);
-                currentSignal = buildDataChunkFrame(chunkData, seq, modName, repetition);
+                current = { chunkData, signal: buildDataChunkFrame(chunkData, seq, modName, repetition) };
             }
 
             // 다음 프레임 미리 빌드 (비동기 시작)
@@ -252,24 +408,46 @@ async function playChunkedFrames() {
             let nextBuildPromise = null;
             if (nextSeq < totalChunks) {
                 nextBuildPromise = readFileChunk(selectedFile, nextSeq, chunkSize).then(
-                    data => buildDataChunkFrame(data, nextSeq, modName, repetition)
+                    data => ({ chunkData: data, signal: buildDataChunkFrame(data, nextSeq, modName, repetition) })
                 );
             }
 
             // 현재 프레임 재생
-            await playSignalAsync(ctx, currentSignal);
+            await playSignalAsync(ctx, current.signal);
+
+            // 그룹의 마지막 청크 뒤에 패리티 프레임
+            if (parityGroup) {
+                if (!parity) { parity = new Uint8Array(chunkSize); groupStart = seq; }
+                xorInto(parity, current.chunkData);
+                if (seq - groupStart + 1 === parityGroup || seq === totalChunks - 1) {
+                    await playSignalAsync(ctx, buildParityFrame(groupStart, seq - groupStart + 1, parity, modName, repetition));
+                    parity = null;
+                }
+            }
 
             // 다음 프레임 빌드 완료 대기
             if (nextBuildPromise) {
-                nextFrameSignal = await nextBuildPromise;
+                nextFrame = await nextBuildPromise;
             }
 
             // 진행률 업데이트
-            const elapsed = (Date.now() - sendStartTime) / 1000;
+            const bytesSent = Math.min((seq + 1) * chunkSize, fileSize);
+            rateMeter.add(bytesSent);
             const progress = (seq + 1) / totalChunks;
-            const eta = elapsed / progress * (1 - progress);
-            updateChunkProgressUI(seq + 1, totalChunks, eta);
-            updateProgress(progress, `청크 ${seq + 1}/${totalChunks} 전송 완료 · ETA: ${formatETA(eta)}`);
+            const rate = rateMeter.rate();
+            const eta = rateMeter.eta(fileSize - bytesSent);
+            updateChunkProgressUI(seq + 1, totalChunks, eta, rate);
+            updateProgress(progress, `청크 ${seq + 1}/${totalChunks} 전송 완료 · ${formatRate(rate)} · ETA: ${formatETA(eta)}`);
+
+            if (chunkedSendPaused && seq + 1 < totalChunks) {
+                addLog('info', `일시정지: 청크 ${seq + 1}/${totalChunks}까지 전송됨`);
+                updateProgress(progress, `일시정지됨 — 청크 ${seq + 1}/${totalChunks}`);
+                await new Promise(resolve => { resumeChunkedSend = resolve; });
+                if (chunkedSendAbort) break;
+                addLog('info', `전송 재개: 청크 ${seq + 2}/${totalChunks}부터`);
+                rateMeter = new RateMeter(); // the pause would drag the rate down
+                rateMeter.add(bytesSent);
+            }
         }
 
         if (chunkedSendAbort) {
@@ -291,9 +469,36 @@ function finishChunkedSend(btn, errorMsg) {
     btn.textContent = '전송 시작';
     btn.onclick = () => startSend();
     chunkedSendAbort = false;
+    setChunkedSendPaused(false);
+    document.getElementById('btn-pause-send').style.display = 'none';
     if (errorMsg) addLog('warn', errorMsg);
 }
 
+// A pause takes effect once the frame on air has finished. The receiver has
+// no timeouts and every chunk carries its sequence number, so it just sees
+// a longer gap; nothing needs to be signalled to it.
+function setChunkedSendPaused(paused) {
+    chunkedSendPaused = paused;
+    document.getElementById('btn-pause-send').textContent = paused ? '재개' : '일시정지';
+    if (!paused && resumeChunkedSend) {
+        resumeChunkedSend();
+        resumeChunkedSend = null;
+    }
+}
+
+function toggleChunkedSendPause() {
+    setChunkedSendPaused(!chunkedSendPaused);
+    if (chunkedSendPaused) addLog('info', '현재 프레임 전송 후 일시정지합니다');
+}
+
+// Chunk by chunk, so a large file is never held whole
+async function hashFile(file, chunkSize, alg) {
+    const hasher = new FileHasher(alg);
+    const totalChunks = Math.ceil(file.size / chunkSize);
+    for (let seq = 0; seq < totalChunks; seq++) hasher.update(await readFileChunk(file, seq, chunkSize));
+    return { alg, digest: hasher.digest() };
+}
+
 async function readFileChunk(file, seqNum, chunkSize) {
     const start = seqNum * chunkSize;
     const end = Math.min(start + chunkSize, file.size);
@@ -302,9 +507,11 @@ async function readFileChunk(file, seqNum, chunkSize) {
     return new Uint8Array(arrayBuf);
 }
 
-function playSignalAsync(ctx, signal) {
+// The buffer keeps the signal's own rate; Web Audio resamples it to the
+// device rate on playback.
+function playSignalAsync(ctx, signal, sampleRate) {
     return new Promise((resolve) => {
-        const sr = ctx.sampleRate || 44100;
+        const sr = sampleRate || OFDM.SAMPLE_RATE;
         const buffer = ctx.createBuffer(1, signal.length, sr);
         buffer.getChannelData(0).set(signal);
         const source = ctx.createBufferSource();
@@ -312,18 +519,147 @@ function playSignalAsync(ctx, signal) {
         source.connect(ctx.destination);
         source.onended = resolve;
         source.start();
+        gateInputWhileTransmitting(ctx, buffer.duration);
     });
 }
 
-function updateChunkProgressUI(sent, total, eta) {
+// --- Message (파일 없이 짧은 텍스트) ---
+// One tagged frame in the current modulation; the receiver logs it instead
+// of offering a download. MFSK only carries files.
+async function sendTextMessage() {
+    const text = document.getElementById('message-text').value;
+    if (!text || isSending) return;
+    if (modulation === 'MFSK') {
+        addLog('warn', 'MFSK로는 메시지를 보낼 수 없습니다 — 다른 변조 방식을 고르세요');
+        return;
+    }
+    if (beaconRun) toggleBeacon();
+    const { config, modName, repetition } = getModemParams(modulation);
+    setOFDMConfig(config);
+    if (!checkDeviceBand(getAudioContext())) return;
+
+    const data = new TextEncoder().encode(text);
+    const contentType = 'text/plain';
+    const signal = buildMessageFrame(data, contentType, modName, repetition);
+    if (signal.error) {
+        const limit = MAX_MESSAGE_BYTES - contentType.length;
+        addLog('error', `메시지는 ${formatSize(limit)} 이하만 보낼 수 있습니다 (${formatSize(data.length)})`);
+        return;
+    }
+    const btn = document.getElementById('btn-send-message');
+    btn.disabled = true;
+    isSending = true;
+    addLog('info', `메시지 전송 시작 (${formatSize(data.length)}, ${(signal.length / OFDM.SAMPLE_RATE).toFixed(1)}초)`);
+    try {
+        await playSignalAsync(getAudioContext(), signal);
+        addLog('success', '메시지 전송 완료');
+    } finally {
+        btn.disabled = false;
+        isSending = false;
+    }
+}
+
+// Text types are logged as text, anything else by type and size
+function logReceivedMessage(msg) {
+    const size = formatSize(msg.data.length);
+    if (!/^text\//.test(msg.contentType)) {
+        addLog('success', `메시지 수신 (${msg.contentType || '형식 없음'}, ${size})`);
+        return;
+    }
+    let text = '';
+    try { text = new TextDecoder().decode(msg.data); } catch (e) {}
+    addLog('success', `메시지 수신 (${msg.contentType}, ${size}): ${text}`);
+}
+
+// --- Beacon (송신 전 상대 확인) ---
+// While on, a beacon frame goes out every BEACON_INTERVAL_MS, so a peer in
+// streaming receive sees this node is in range and how well it is heard
+// before any file is sent. Starting a send stops it.
+const BEACON_INTERVAL_MS = 2000;
+const beaconNodeId = (Math.random() * 0x100000000) >>> 0;
+let beaconRun = null; // { stopped } of the running beacon loop
+
+function formatNodeId(id) {
+    return id.toString(16).toUpperCase().padStart(8, '0');
+}
+
+async function toggleBeacon() {
+    const btn = document.getElementById('btn-beacon');
+    if (beaconRun) {
+        beaconRun.stopped = true;
+        beaconRun = null;
+        btn.textContent = '비콘 송신';
+        addLog('info', '비콘 송신 중지');
+        return;
+    }
+    if (isSending) return;
+
+    const run = { stopped: false };
+    beaconRun = run;
+    btn.textContent = '비콘 중지';
+    addLog('info', `비콘 송신 시작: 노드 ${formatNodeId(beaconNodeId)} (${BEACON_INTERVAL_MS / 1000}초 간격)`);
+    const ctx = getAudioContext();
+    for (let counter = 0; !run.stopped; counter++) {
+        setOFDMConfig(getModemParams(modulation).config);
+        await playSignalAsync(ctx, buildBeaconFrame(beaconNodeId, counter));
+        await sleep(BEACON_INTERVAL_MS);
+    }
+}
+
+// --- Input Gate (own transmission) ---
+// Receive callbacks get silence instead of our own playback (plus a reverb
+// tail); zeros keep the sample timeline continuous.
+const TX_GATE_TAIL = 0.2; // seconds
+let txGateUntil = 0;      // AudioContext time
+
+function gateInputWhileTransmitting(ctx, duration) {
+    txGateUntil = Math.max(txGateUntil, ctx.currentTime + duration + TX_GATE_TAIL);
+}
+
+function isInputGated(ctx) {
+    return ctx.currentTime < txGateUntil;
+}
+
+function updateChunkProgressUI(sent, total, eta, rate) {
     const panel = document.getElementById('chunk-progress');
     if (panel) {
         panel.style.display = 'block';
         const countEl = document.getElementById('chunk-count');
         const etaEl = document.getElementById('chunk-eta');
         if (countEl) countEl.textContent = `${sent} / ${total} 청크`;
-        if (etaEl) etaEl.textContent = `남은 시간: ${formatETA(eta)}`;
+        if (etaEl) etaEl.textContent = `남은 시간: ${formatETA(eta)} · ${formatRate(rate)}`;
+    }
+}
+
+// Instantaneous transfer rate over a sliding window of (time, total bytes)
+// samples. rate() is null until two samples exist and decays toward zero
+// while no new bytes arrive, so a stalled transfer shows up as such.
+class RateMeter {
+    constructor(windowMs) {
+        this.windowMs = windowMs || 5000;
+        this.points = [];
+    }
+
+    add(totalBytes, now = Date.now()) {
+        this.points.push({ t: now, bytes: totalBytes });
+        while (this.points.length > 2 && now - this.points[1].t > this.windowMs) this.points.shift();
     }
+
+    rate(now = Date.now()) {
+        if (this.points.length < 2) return null;
+        const first = this.points[0], last = this.points[this.points.length - 1];
+        const dt = (now - first.t) / 1000;
+        return dt > 0 ? (last.bytes - first.bytes) / dt : null;
+    }
+
+    eta(remainingBytes, now = Date.now()) {
+        const r = this.rate(now);
+        return r ? remainingBytes / r : null;
+    }
+}
+
+function formatRate(bytesPerSec) {
+    return bytesPerSec === null ? '--' : `${formatSize(Math.round(bytesPerSec))}/s`;
 }
 
 function formatETA(seconds) {
@@ -335,6 +671,115 @@ function formatETA(seconds) {
     return `${h}시간 ${m}분`;
 }
 
+// --- WAV Save (하드웨어 없이 데모) ---
+
+// 전송될 전체 신호를 WAV로 저장 (소규모: 단일 프레임, 대용량: 메타 + 모든 청크)
+// Rendered frame by frame into 16-bit Blob parts, but the parts still sit
+// in memory until the download, so long transfers go out live instead
+const WAV_MAX_SECONDS = 3600;
+
+async function saveSignalAsWAV() {
+    if (!selectedFile) return;
+
+    const { config, modName, repetition } = getModemParams(modulation);
+    setOFDMConfig(config);
+
+    const parts = [];
+    let numSamples = 0;
+    const addSignal = signal => {
+        parts.push(encodePCM16(signal));
+        numSamples += signal.length;
+    };
+
+    try {
+        if (modulation === 'MFSK') {
+            const signal = new MFSKModem().encode(new Uint8Array(await selectedFile.arrayBuffer()), selectedFileName);
+            if (signal.error) {
+                addLog('error', `MFSK는 ${formatSize(MFSK_MAX_BYTES)} 이하의 파일만 보낼 수 있습니다`);
+                return;
+            }
+            addLog('info', `WAV 렌더링 시작 (${modulation})`);
+            addSignal(signal);
+        } else if (selectedFile.size <= CHUNK_THRESHOLD) {
+            addLog('info', `WAV 렌더링 시작 (${modulation})`);
+            const fileData = new Uint8Array(await selectedFile.arrayBuffer());
+            const result = buildTransmitSignal(fileData, modName, selectedFileName, repetition);
+            if (result.error) {
+                addLog('error', `변조 실패: ${result.error}`);
+                return;
+            }
+            addSignal(result.signal);
+        } else {
+            const chunkSize = getChunkSize(modName);
+            const totalChunks = Math.ceil(selectedFile.size / chunkSize);
+            const numFrames = totalChunks + (OFDM.parityGroup ? Math.ceil(totalChunks / OFDM.parityGroup) : 0);
+            const seconds = numFrames * estimateFrameSamplesWithSilence(chunkSize + CHUNK_FRAME_OVERHEAD, modName, repetition, false) / OFDM.SAMPLE_RATE;
+            if (seconds > WAV_MAX_SECONDS) {
+                addLog('error', `WAV 저장은 ${WAV_MAX_SECONDS / 60}분 분량까지만 됩니다 (이 파일은 약 ${Math.ceil(seconds / 60)}분) — 실시간 전송을 쓰세요`);
+                return;
+            }
+            addLog('info', `WAV 렌더링 시작 (${modulation})`);
+
+            const fileHash = OFDM.fileHash ? await hashFile(selectedFile, chunkSize, OFDM.fileHash) : null;
+            addSignal(buildMetadataFrame(totalChunks, selectedFile.size, chunkSize, selectedFileName, modName, repetition, fileHash));
+            let parity = null, groupStart = 0;
+            for (let seq = 0; seq < totalChunks; seq++) {
+                const chunkData = await readFileChunk(selectedFile, seq, chunkSize);
+                addSignal(buildDataChunkFrame(chunkData, seq, modName, repetition));
+                if (!OFDM.parityGroup) continue;
+                if (!parity) { parity = new Uint8Array(chunkSize); groupStart = seq; }
+                xorInto(parity, chunkData);
+                if (seq - groupStart + 1 === OFDM.parityGroup || seq === totalChunks - 1) {
+                    addSignal(buildParityFrame(groupStart, seq - groupStart + 1, parity, modName, repetition));
+                    parity = null;
+                }
+            }
+        }
+
+        const wav = new Blob([wavHeader(numSamples, OFDM.SAMPLE_RATE), ...parts]);
+        downloadBlob(wav, `${selectedFileName}.${modulation}.wav`, 'audio/wav');
+        addLog('success', `WAV 저장: ${(numSamples / OFDM.SAMPLE_RATE).toFixed(1)}초 (${formatSize(wav.size)})`);
+    } catch (err) {
+        addLog('error', `WAV 렌더링 오류: ${err.message}`);
+    }
+}
+
+function downloadBlob(data, fileName, type) {
+    const url = URL.createObjectURL(new Blob([data], { type }));
+    const a = document.createElement('a');
+    a.href = url;
+    a.download = fileName;
+    a.click();
+    setTimeout(() => URL.revokeObjectURL(url), 1000);
+}
+
+// --- WAV Load (녹음 대신 파일을 트리머로) ---
+async function loadWAVFile(event) {
+    const file = event.target.files[0];
+    event.target.value = '';
+    if (!file) return;
+
+    const { config } = getModemParams(modulation);
+    setOFDMConfig(config);
+
+    const wav = decodeWAV(new Uint8Array(await file.arrayBuffer()));
+    if (wav.error) {
+        addLog('error', `WAV 읽기 실패: ${wav.error}`);
+        return;
+    }
+    if (wav.numChannels > 1) {
+        addLog('info', `WAV ${wav.numChannels}채널 — MRC로 결합`);
+    }
+    if (wav.sampleRate !== OFDM.SAMPLE_RATE) {
+        addLog('info', `WAV 리샘플링: ${wav.sampleRate} Hz → ${OFDM.SAMPLE_RATE} Hz`);
+    }
+
+    fullSignal = resample(wav.samples, wav.sampleRate, OFDM.SAMPLE_RATE);
+    showProgress();
+    addLog('info', `WAV 로드: ${file.name} (${(fullSignal.length / OFDM.SAMPLE_RATE).toFixed(1)}초)`);
+    showWaveformTrimmer();
+}
+
 // --- Receive ---
 async function startReceive() {
     const btn = document.getElementById('btn-receive');
@@ -352,6 +797,7 @@ async function startReceive() {
                 noiseSuppression: false,
                 autoGainControl: false,
                 sampleRate: 44100,
+                channelCount: { ideal: 2 },
             }
         });
         addLog('info', '마이크 권한 허용됨');
@@ -387,20 +833,32 @@ async function startReceive() {
     levelCanvas.width = levelCanvas.clientWidth * (window.devicePixelRatio || 1);
     drawLevelMeter(levelAnalyser, levelCanvas);
 
+    // A stereo mic keeps both channels; they are merged with combineMRC on stop
+    const numChannels = micStream.getAudioTracks()[0].getSettings().channelCount === 2 ? 2 : 1;
+    if (numChannels === 2) addLog('info', '스테레오 입력 — 두 채널을 MRC로 결합합니다');
+    recordSampleRate = ctx.sampleRate;
+
     // Use ScriptProcessorNode for broad compatibility
-    const processor = ctx.createScriptProcessor(4096, 1, 1);
+    const processor = ctx.createScriptProcessor(4096, numChannels, 1);
+    const detectDropout = createDropoutDetector(ctx, 4096);
     let totalSamples = 0;
     const maxDuration = getMaxDuration();
 
     processor.onaudioprocess = (e) => {
         if (!isRecording) return;
-        const input = e.inputBuffer.getChannelData(0);
-        recordedChunks.push(new Float32Array(input));
-        totalSamples += input.length;
+        const lost = detectDropout(e);
+        if (lost > 0) addLog('warn', `입력 누락: ${(lost / OFDM.SAMPLE_RATE * 1000).toFixed(0)} ms — 해당 구간의 프레임이 손상될 수 있습니다`);
+        const gated = isInputGated(ctx);
+        const block = [];
+        for (let ch = 0; ch < numChannels; ch++) {
+            block.push(gated ? new Float32Array(e.inputBuffer.length) : applyInputGain(e.inputBuffer.getChannelData(ch)));
+        }
+        recordedChunks.push(block);
+        totalSamples += block[0].length;
 
-        const seconds = totalSamples / 44100;
+        const seconds = totalSamples / recordSampleRate;
         if (seconds % 1 < 0.1) {
-            updateProgress(0, `녹음 중... ${seconds.toFixed(0)}초 (${formatSize(totalSamples * 4)})`);
+            updateProgress(0, `녹음 중... ${seconds.toFixed(0)}초 (${formatSize(totalSamples * 4 * numChannels)})`);
         }
 
         if (seconds >= maxDuration) {
@@ -436,16 +894,27 @@ function stopReceive() {
         return;
     }
 
-    // Concatenate chunks
+    // Concatenate chunks per channel, bring to the modem rate, then combine
     let totalLen = 0;
-    for (const c of recordedChunks) totalLen += c.length;
-    fullSignal = new Float32Array(totalLen);
-    let off = 0;
-    for (const c of recordedChunks) { fullSignal.set(c, off); off += c.length; }
+    for (const block of recordedChunks) totalLen += block[0].length;
+    const channels = recordedChunks[0].map((_, ch) => {
+        const samples = new Float32Array(totalLen);
+        let off = 0;
+        for (const block of recordedChunks) { samples.set(block[ch], off); off += block[ch].length; }
+        return resample(samples, recordSampleRate, OFDM.SAMPLE_RATE);
+    });
     recordedChunks = [];
+    if (recordSampleRate !== OFDM.SAMPLE_RATE) {
+        addLog('info', `리샘플링: ${recordSampleRate} Hz → ${OFDM.SAMPLE_RATE} Hz`);
+    }
+    fullSignal = combineMRC(channels);
 
-    const duration = totalLen / 44100;
+    const duration = fullSignal.length / OFDM.SAMPLE_RATE;
     addLog('info', `녹음 완료: ${duration.toFixed(1)}초 — 파형을 확인하고 구간을 선택하세요`);
+    showWaveformTrimmer();
+}
+
+function showWaveformTrimmer() {
     updateProgress(0, '트림 구간을 선택한 후 [선택 구간 복조]를 누르세요');
 
     // 파형 트리머 표시
@@ -472,7 +941,7 @@ function stopReceive() {
 
 function updateTrimLabels() {
     if (!fullSignal) return;
-    const duration = fullSignal.length / 44100;
+    const duration = fullSignal.length / OFDM.SAMPLE_RATE;
     const startVal = parseInt(document.getElementById('trim-start').value);
     const endVal = parseInt(document.getElementById('trim-end').value);
     const startSec = (startVal / 1000) * duration;
@@ -484,10 +953,11 @@ function updateTrimLabels() {
     document.getElementById('trim-duration-label').textContent = `구간: ${durSec.toFixed(1)}s`;
 }
 
-function demodulateTrimed() {
+// The range selected in the waveform trimmer, or null after logging why not
+function getTrimmedSignal() {
     if (!fullSignal) {
         addLog('warn', '녹음된 신호가 없습니다');
-        return;
+        return null;
     }
 
     const startVal = parseInt(document.getElementById('trim-start').value);
@@ -495,28 +965,46 @@ function demodulateTrimed() {
 
     if (startVal >= endVal) {
         addLog('warn', '시작 지점이 종료 지점보다 앞에 있어야 합니다');
-        return;
+        return null;
     }
 
     const trimStartSample = Math.floor((startVal / 1000) * fullSignal.length);
     const trimEndSample = Math.floor((endVal / 1000) * fullSignal.length);
-    const signal = fullSignal.slice(trimStartSample, trimEndSample);
+    return fullSignal.slice(trimStartSample, trimEndSample);
+}
+
+function demodulateTrimed() {
+    const signal = getTrimmedSignal();
+    if (!signal) return;
 
-    const duration = signal.length / 44100;
+    const duration = signal.length / OFDM.SAMPLE_RATE;
     addLog('info', `트림된 구간 복조 시작: ${duration.toFixed(1)}초 (${formatSize(signal.length * 4)})`);
     updateProgress(0.3, '복조 중...');
 
     setTimeout(() => {
         try {
+            if (modulation === 'MFSK') {
+                demodulateMFSKRecording(signal);
+                return;
+            }
             const { config, modName, repetition } = getModemParams(modulation);
             setOFDMConfig(config);
             const result = decodeReceivedSignal(signal, modName, repetition);
 
+            // Trimmed range holds a chunked transmission or a message (maybe
+            // more than one): decode every frame in it
+            if (result.frameType === FRAME_META || result.frameType === FRAME_DATA || result.frameType === FRAME_MESSAGE) {
+                demodulateChunkedRecording(signal, modName, repetition);
+                return;
+            }
+
             if (result.error) {
                 addLog('error', `복조 실패: ${result.error}`);
                 updateProgress(0, `오류: ${result.error}`);
                 return;
             }
+            logSuggestedMask(result.suggestedMask);
+            if (result.reverbWarning) logReverbWarning(result.delaySpread);
 
             if (result.crcValid) {
                 addLog('success', `수신 성공! ${result.fileName || 'file'} — CRC 검증 통과 (${formatSize(result.dataLen)})`);
@@ -535,13 +1023,130 @@ function demodulateTrimed() {
     }, 100);
 }
 
+// Feeds the selected range to a StreamingReceiver block by block, as the
+// microphone would; each frame's demodulation is awaited, not paced.
+let isReplaying = false;
+
+async function replayTrimmedStreaming() {
+    if (isStreamingReceive || isReplaying) {
+        addLog('warn', '스트리밍 수신이 이미 진행 중입니다');
+        return;
+    }
+    if (modulation === 'MFSK') {
+        addLog('warn', 'MFSK는 [선택 구간 복조]로 복조하세요');
+        return;
+    }
+    const signal = getTrimmedSignal();
+    if (!signal) return;
+
+    const { config, modName, repetition } = getModemParams(modulation);
+    setOFDMConfig(config);
+    isReplaying = true;
+    showProgress();
+    addLog('info', `스트리밍 수신으로 재생: ${(signal.length / OFDM.SAMPLE_RATE).toFixed(1)}초`);
+
+    const receiver = new StreamingReceiver(modName, repetition);
+    const block = 4096;
+    // Trailing silence lets a frame that ends at the range end complete
+    const total = signal.length + OFDM.SAMPLE_RATE / 2;
+    try {
+        for (let off = 0; off < total; off += block) {
+            const samples = off < signal.length ? signal.subarray(off, off + block) : new Float32Array(block);
+            receiver.processAudioBlock(samples);
+            await receiver.finish();
+            if (receiver.metaReceived) updateStreamingUI(receiver);
+            else updateProgress(off / total, `재생 중... ${(off / OFDM.SAMPLE_RATE).toFixed(0)}초`);
+            await sleep(0);
+        }
+        addLog('info', `재생 완료: 프레임 ${receiver.framesDecoded}개 복조, 실패 ${receiver.frameErrors}개`);
+        await finishStreamingReceive(receiver);
+    } finally {
+        isReplaying = false;
+    }
+}
+
+function demodulateMFSKRecording(signal) {
+    const result = new MFSKModem().decode(signal);
+    if (result.error && !result.data) {
+        addLog('error', `MFSK 복조 실패: ${result.error}`);
+        updateProgress(0, `오류: ${result.error}`);
+        return;
+    }
+    const name = result.fileName || 'received_file';
+    if (result.error) {
+        addLog('error', `MFSK CRC 불일치 — 데이터가 손상되었을 수 있습니다`);
+        updateProgress(0.9, 'CRC 불일치 — 데이터 손상 가능');
+        offerDownload(result.data, name + '.corrupted');
+        return;
+    }
+    addLog('success', `수신 성공! ${name} — MFSK, CRC 검증 통과 (${formatSize(result.data.length)})`);
+    updateProgress(1.0, `수신 완료: ${name} (${formatSize(result.data.length)})`);
+    offerDownload(result.data, name);
+}
+
+function demodulateChunkedRecording(signal, modName, repetition) {
+    const frames = decodeFrames(signal, modName, repetition);
+    const decoded = frames.filter(f => f.crcValid).length;
+    addLog('info', `청크 프레임 ${frames.length}개 탐지, ${decoded}개 CRC 통과`);
+    if (frames.length && frames[frames.length - 1].incomplete) {
+        addLog('warn', '마지막 프레임이 선택 구간 끝에서 잘렸습니다');
+    }
+
+    const messages = frames.filter(f => f.frameType === FRAME_MESSAGE && f.crcValid);
+    messages.forEach(logReceivedMessage);
+
+    const file = assembleChunkFrames(frames);
+    if (file.data && !file.fileName) file.fileName = 'received_file';
+    if (!file.data && messages.length) {
+        updateProgress(1.0, `메시지 ${messages.length}개 수신`);
+        return;
+    }
+    if (!file.data) {
+        addLog('error', `복조 실패: ${file.error}`);
+        updateProgress(0, `오류: ${file.error}`);
+        return;
+    }
+    if (file.missing.length) {
+        addLog('warn', `누락 청크 ${file.missing.length}/${file.totalChunks}개 — 0으로 채워 부분 파일로 저장합니다`);
+        updateProgress(0.9, `부분 수신: ${file.totalChunks - file.missing.length}/${file.totalChunks} 청크`);
+        offerDownload(file.data, file.fileName + '.partial');
+        return;
+    }
+    if (file.hashValid === false) {
+        addLog('error', `복조 실패: ${file.error} — 청크 CRC는 통과했지만 파일이 원본과 다릅니다`);
+        updateProgress(0, `오류: ${file.error}`);
+        offerDownload(file.data, file.fileName + '.corrupted');
+        return;
+    }
+    const hashNote = file.hashValid ? `, ${FILE_HASH_NAMES[file.hashAlg]} 일치` : '';
+    addLog('success', `수신 성공! ${file.fileName} — ${file.totalChunks}개 청크 CRC 검증 통과${hashNote} (${formatSize(file.data.length)})`);
+    updateProgress(1.0, `수신 완료: ${file.fileName} (${formatSize(file.data.length)})`);
+    offerDownload(file.data, file.fileName);
+}
+
+// What happens when a received file has the name of an earlier one:
+// 'rename' offers it as "name (1).ext", 'refuse' drops the new file.
+let receivedNamePolicy = 'rename';
+const receivedFiles = new Map(); // offered name → list item
+
 function offerDownload(data, defaultName) {
+    let fileName = defaultName;
+    if (receivedFiles.has(fileName)) {
+        if (receivedNamePolicy === 'refuse') {
+            addLog('warn', `같은 이름의 파일이 이미 수신되어 무시합니다: ${fileName}`);
+            return;
+        } else {
+            fileName = uniqueFileName(defaultName, name => receivedFiles.has(name));
+            addLog('info', `같은 이름의 파일이 이미 있어 ${fileName}(으)로 저장합니다`);
+        }
+    }
+
     const blob = new Blob([data]);
     const url = URL.createObjectURL(blob);
     const a = document.createElement('a');
     a.href = url;
-    a.download = defaultName;
-    a.textContent = `${defaultName} (${formatSize(data.length)})`;
+    a.download = fileName;
+    a.textContent = `${fileName} (${formatSize(data.length)})`;
     a.className = 'download-link';
 
     const container = document.getElementById('received-files');
@@ -549,6 +1154,7 @@ function offerDownload(data, defaultName) {
     item.className = 'file-item';
     item.appendChild(a);
     container.appendChild(item);
+    receivedFiles.set(fileName, item);
 
     addLog('info', '파일 다운로드 링크가 생성되었습니다');
 }
@@ -557,7 +1163,21 @@ function offerDownload(data, defaultName) {
 // Streaming Receiver — Real-time Frame Detection & Demodulation
 // ============================================================
 
+// Failure reason for samples lost before demodulation (alongside DECODE_FAIL)
+const FAIL_OVERRUN = 'overrun';
+
 const RECV_STATE = { IDLE: 0, PREAMBLE_DETECTED: 1, COLLECTING_FRAME: 2, DEMODULATING: 3 };
+
+// Frame events kept for diagnostics; older ones are dropped so a long
+// transfer does not grow the log without bound
+const FRAME_LOG_SIZE = 256;
+const FRAME_TYPE_NAMES = { [FRAME_META]: 'meta', [FRAME_DATA]: 'data', [FRAME_PARITY]: 'parity', [FRAME_BEACON]: 'beacon', [FRAME_MESSAGE]: 'message' };
+
+// Input quieter than this (DC removed) for NO_SIGNAL_SECONDS is reported as
+// no signal. That is ~20 dB below the quietest frames the streaming
+// receiver still decodes, so a weak but usable signal never trips it.
+const NO_SIGNAL_DB = -70;
+const NO_SIGNAL_SECONDS = 5;
 let streamingReceiver = null;
 
 class RingBuffer {
@@ -594,15 +1214,22 @@ class RingBuffer {
     }
 }
 
+// Parity frames kept while their group is missing more than one chunk
+const MAX_PENDING_PARITY = 64;
+
 class ChunkAssembler {
     constructor() {
         this.totalChunks = 0;
         this.totalFileSize = 0;
         this.chunkSize = 0;
         this.fileName = '';
+        this.fileHash = null; // { alg, digest } from the metadata, if the sender added one
         this.receivedBitmap = null;
         this.receivedCount = 0;
         this.crcErrors = 0;
+        this.crcFailedSeqs = new Set(); // heard, but every copy failed CRC
+        this.pendingParity = new Map(); // firstSeq → parity frame, group still short of >1 chunk
+        this.recoveredChunks = 0; // rebuilt from a parity frame
         this.dbName = 'audioModemChunks';
         this.db = null;
     }
@@ -612,9 +1239,13 @@ class ChunkAssembler {
         this.totalFileSize = meta.totalFileSize;
         this.chunkSize = meta.chunkSize;
         this.fileName = meta.fileName;
+        this.fileHash = meta.fileHash;
         this.receivedBitmap = new Uint8Array(Math.ceil(this.totalChunks / 8));
         this.receivedCount = 0;
         this.crcErrors = 0;
+        this.crcFailedSeqs = new Set();
+        this.pendingParity = new Map();
+        this.recoveredChunks = 0;
 
         // Initialize IndexedDB
         if (this.db) this.db.close();
@@ -630,30 +1261,83 @@ class ChunkAssembler {
         if (seqNum >= this.totalChunks) return;
 
         if (!crcValid) {
+            // The sequence number itself may be corrupt, so this is a hint only
             this.crcErrors++;
+            this.crcFailedSeqs.add(seqNum);
             return;
         }
 
-        // Mark as received
-        const byteIdx = seqNum >> 3;
-        const bitIdx = seqNum & 7;
-        if (this.receivedBitmap[byteIdx] & (1 << bitIdx)) return; // duplicate
-        this.receivedBitmap[byteIdx] |= (1 << bitIdx);
+        if (this.isReceived(seqNum)) return; // duplicate
+        await this._storeChunk(seqNum, data);
+
+        // A parity frame heard earlier may now be able to fill in its group
+        for (const parity of this.pendingParity.values()) {
+            if (seqNum >= parity.firstSeq && seqNum < parity.firstSeq + parity.count) {
+                await this.handleParityFrame(parity);
+                break;
+            }
+        }
+    }
+
+    // Rebuilds the group's one missing chunk from the parity frame and the
+    // stored others. Returns the recovered sequence number, or -1. A group
+    // still missing several chunks keeps its parity for later.
+    async handleParityFrame(parity) {
+        if (!this.receivedBitmap || !parity.crcValid) return -1;
+        const group = [];
+        for (let seq = parity.firstSeq; seq < Math.min(parity.firstSeq + parity.count, this.totalChunks); seq++) group.push(seq);
+        const lost = group.filter(seq => !this.isReceived(seq));
+        if (lost.length > 1) {
+            if (this.pendingParity.size >= MAX_PENDING_PARITY) {
+                this.pendingParity.delete(this.pendingParity.keys().next().value);
+            }
+            this.pendingParity.set(parity.firstSeq, parity);
+            return -1;
+        }
+        this.pendingParity.delete(parity.firstSeq);
+        if (lost.length === 0) return -1;
+
+        const others = [];
+        for (const seq of group) if (seq !== lost[0]) others.push(await this._readChunk(seq));
+        const len = chunkLength(lost[0], this.chunkSize, this.totalFileSize);
+        await this._storeChunk(lost[0], recoverChunkFromParity(parity.parity, others, len));
+        this.recoveredChunks++;
+        return lost[0];
+    }
+
+    async _storeChunk(seqNum, data) {
+        this.receivedBitmap[seqNum >> 3] |= (1 << (seqNum & 7));
         this.receivedCount++;
 
-        // Store in IndexedDB
         const tx = this.db.transaction('chunks', 'readwrite');
         tx.objectStore('chunks').put({ seqNum, data: new Uint8Array(data) });
         await new Promise((resolve, reject) => { tx.oncomplete = resolve; tx.onerror = reject; });
     }
 
+    async _readChunk(seqNum) {
+        const req = this.db.transaction('chunks', 'readonly').objectStore('chunks').get(seqNum);
+        const record = await new Promise((resolve, reject) => {
+            req.onsuccess = () => resolve(req.result);
+            req.onerror = reject;
+        });
+        return record.data;
+    }
+
+    // A CRC-valid chunk can still be from another transfer (e.g. the old
+    // metadata was missed); its length has to match this file's layout
+    chunkLengthValid(seqNum, length) {
+        return length === chunkLength(seqNum, this.chunkSize, this.totalFileSize);
+    }
+
     isReceived(seqNum) {
         if (!this.receivedBitmap) return false;
         return !!(this.receivedBitmap[seqNum >> 3] & (1 << (seqNum & 7)));
     }
 
     isComplete() {
-        return this.receivedCount === this.totalChunks;
+        // No metadata yet (e.g. it was corrupted) means nothing to complete;
+        // an empty file is complete as soon as its metadata arrives
+        return this.receivedBitmap !== null && this.receivedCount === this.totalChunks;
     }
 
     getMissingChunks() {
@@ -664,6 +1348,12 @@ class ChunkAssembler {
         return missing;
     }
 
+    // Missing chunks that did arrive but only with CRC errors (as opposed to
+    // never being heard, e.g. a missed preamble)
+    getCorruptedChunks() {
+        return this.getMissingChunks().filter(i => this.crcFailedSeqs.has(i));
+    }
+
     async assembleFile() {
         const result = new Uint8Array(this.totalFileSize);
         const tx = this.db.transaction('chunks', 'readonly');
@@ -709,8 +1399,7 @@ class StreamingReceiver {
         this.repetition = repetition;
 
         // Ring buffer: enough for 2 max frames + some margin
-        const maxPayload = 4096 + 16; // max chunk + overhead
-        const maxFrameSamples = estimateFrameSamples(maxPayload, modName, repetition);
+        const maxFrameSamples = estimateFrameSamples(MAX_FRAME_PAYLOAD, modName, repetition);
         const capacity = maxFrameSamples * 3 + 8192;
         this.ringBuffer = new RingBuffer(capacity);
 
@@ -725,9 +1414,11 @@ class StreamingReceiver {
         this.acRb = 0;
         this.acInitialized = false;
         this.acScanPos = 0; // global scan position
+        this.acCombiner = new PreambleCombiner(); // sums over repeated preambles
 
         // Preamble detection state
         this.preambleGlobalPos = -1;
+        this.headerEnd = -1;
         this.expectedFrameEnd = -1;
 
         // DC removal state (exponential moving average)
@@ -737,12 +1428,36 @@ class StreamingReceiver {
         // Stats
         this.framesDecoded = 0;
         this.frameErrors = 0;
+        this.errorReasons = {}; // DECODE_FAIL reason → count
+        this.frameTypes = {};   // type name → { ok, failed }; 'unknown' when lost before the type byte
+        this.frameLog = [];     // last FRAME_LOG_SIZE frame events
+        this.bytesReceived = 0; // payload bytes in CRC-valid chunks
+        this.rateMeter = new RateMeter();
+        this.snrSum = 0;
+        this.snrCount = 0;
+        this.suggestedMask = FULL_SUB_MASK; // last subcarrier mask suggestion logged
+        this.reverbWarned = false;
+        this.versionWarned = false;
+        this.combiner = new SoftCombiner(repetition); // damaged frame copies
+        this.framesCombined = 0; // frames only decodable by combining copies
+        this.dropouts = 0;       // input gaps reported by the audio callback
+        this.droppedSamples = 0;
+        this.silentSamples = 0;  // consecutive input below NO_SIGNAL_DB
+        this.noSignal = false;
+        this.inputRmsDb = -Infinity; // last block
+        this.clippedSamples = 0; // input samples on the ±1.0 rails
+        this.clipWarned = false;
+        this.feedback = new FeedbackDetector(); // speaker-to-mic howl
+        this.feedbackEvents = 0;
+        this.beacons = new Map(); // nodeId → { first, last, heard, snrDb }
+        this.messages = [];       // { contentType, data } of intact message frames
         this.startTime = Date.now();
 
         // Pre-generate preamble for cross-correlation
         this.pre1 = generatePreambleSymbol1();
-        this.pre1Energy = 0;
-        for (let i = 0; i < this.pre1.length; i++) this.pre1Energy += this.pre1[i] * this.pre1[i];
+        // Out-of-band rejection; only delays the stream by bandpass.delay
+        this.bandpass = new BandpassFilter();
+        this.inFlight = null;
     }
 
     // Called from ScriptProcessor callback
@@ -753,8 +1468,11 @@ class StreamingReceiver {
             this.dcMean = this.dcAlpha * this.dcMean + (1 - this.dcAlpha) * inputSamples[i];
             cleaned[i] = inputSamples[i] - this.dcMean;
         }
+        this._trackInputLevel(cleaned);
+        this._checkClipping(inputSamples);
+        this._checkFeedback(cleaned);
 
-        this.ringBuffer.write(cleaned);
+        this.ringBuffer.write(this.bandpass.process(cleaned));
 
         switch (this.state) {
             case RECV_STATE.IDLE:
@@ -772,6 +1490,62 @@ class StreamingReceiver {
         }
     }
 
+    // Warns once per silent stretch, e.g. a muted or unplugged input or a
+    // sender that never started, instead of just waiting
+    _trackInputLevel(samples) {
+        let sumSq = 0;
+        for (let i = 0; i < samples.length; i++) sumSq += samples[i] * samples[i];
+        const rms = samples.length ? Math.sqrt(sumSq / samples.length) : 0;
+        this.inputRmsDb = rms > 0 ? 20 * Math.log10(rms) : -Infinity;
+
+        if (this.inputRmsDb >= NO_SIGNAL_DB) {
+            this.silentSamples = 0;
+            if (this.noSignal) {
+                this.noSignal = false;
+                addLog('info', `입력 신호 감지 (${this.inputRmsDb.toFixed(0)} dBFS)`);
+            }
+            return;
+        }
+        this.silentSamples += samples.length;
+        if (!this.noSignal && this.silentSamples >= NO_SIGNAL_SECONDS * OFDM.SAMPLE_RATE) {
+            this.noSignal = true;
+            addLog('warn', `신호 없음 — ${NO_SIGNAL_SECONDS}초 넘게 입력이 무음입니다 (${NO_SIGNAL_DB} dBFS 미만). 마이크/케이블 연결과 입력 볼륨을 확인하세요`);
+            if (!this.metaReceived) updateProgress(0, '신호 없음 — 마이크/케이블 연결과 볼륨을 확인하세요');
+        }
+    }
+
+    // Clipped frames fail CRC no matter how good the link is, so say why once
+    _checkClipping(samples) {
+        const input = classifyInputLevel(samples);
+        if (input.level !== 'clipping') return;
+        this.clippedSamples += input.clippedSamples;
+        if (!this.clipWarned) {
+            this.clipWarned = true;
+            addLog('warn', inputGainDb > 0
+                ? `입력 클리핑 — 입력 게인(+${inputGainDb} dB)을 적용한 샘플이 ±1.0에 닿습니다. 입력 게인을 낮추세요`
+                : '입력 클리핑 — 샘플이 ±1.0에 닿습니다. 입력 볼륨(또는 송신 음량)을 낮추세요');
+        }
+    }
+
+    // A howl is a setup problem, not a weak link: point at the speakers
+    _checkFeedback(samples) {
+        if (!this.feedback.process(samples)) return;
+        const howl = this.feedback.howl;
+        if (!howl) {
+            addLog('info', '하울링 멈춤');
+            return;
+        }
+        this.feedbackEvents++;
+        addLog('warn', `하울링 감지 — ${howl.freq.toFixed(0)} Hz 음이 계속 커집니다 (${howl.levelDb.toFixed(0)} dBFS). 스피커와 마이크가 서로 가까이 켜져 있지 않은지 확인하고 볼륨을 낮추세요`);
+        if (!this.metaReceived) updateProgress(0, '하울링 감지 — 스피커/마이크 위치와 볼륨을 확인하세요');
+    }
+
+    // Our own transmission is replaced by zeros on the input; that is not
+    // a missing signal
+    resetSilence() {
+        this.silentSamples = 0;
+    }
+
     _scanForPreamble() {
         const rb = this.ringBuffer;
         const half = this.half;
@@ -788,9 +1562,17 @@ class StreamingReceiver {
         const scanEnd = totalWritten - 2 * half;
         if (this.acScanPos > scanEnd) return;
 
+        // The repeat setting can change while listening; the combiner's
+        // window count has to follow it (see preambleMetricMin)
+        if (this.acCombiner.n !== OFDM.preambleRepeats) {
+            this.acCombiner = new PreambleCombiner();
+            this.acInitialized = false;
+        }
+
         if (!this.acInitialized) {
             // Initialize auto-correlation at acScanPos
             this.acP = 0; this.acRa = 0; this.acRb = 0;
+            this.acCombiner.reset();
             const seg = rb.getRange(this.acScanPos, 2 * half);
             if (!seg) return;
             for (let m = 0; m < half; m++) {
@@ -802,33 +1584,34 @@ class StreamingReceiver {
             this.acInitialized = true;
         }
 
-        const minEnergy = 0.001;
+        const minEnergy = 0.001, threshold = preambleMetricMin();
         let bestMetric = 0, bestPos = -1;
 
         while (this.acScanPos <= scanEnd) {
-            if (this.acRa > minEnergy && this.acRb > minEnergy) {
-                const metric = (this.acP * this.acP) / (this.acRa * this.acRb);
-                if (metric > 0.5 && metric > bestMetric) {
-                    bestMetric = metric;
-                    bestPos = this.acScanPos;
-                }
+            const metric = this.acCombiner.push(this.acScanPos, this.acP, this.acRa, this.acRb, minEnergy);
+            if (metric > threshold && metric > bestMetric) {
+                bestMetric = metric;
+                bestPos = this.acScanPos;
             }
 
-            if (this.acScanPos < scanEnd) {
-                // Sliding update
-                const seg3 = rb.getRange(this.acScanPos, 2 * half + 1);
-                if (!seg3) break;
-                const aOut = seg3[0], mid = seg3[half], bIn = seg3[2 * half];
-                this.acP  += mid * bIn  - aOut * mid;
-                this.acRa += mid * mid  - aOut * aOut;
-                this.acRb += bIn * bIn  - mid  * mid;
-            }
+            // The sums stay on the last window; the next block resumes there
+            if (this.acScanPos === scanEnd) break;
+
+            // Sliding update
+            const seg3 = rb.getRange(this.acScanPos, 2 * half + 1);
+            if (!seg3) break;
+            const aOut = seg3[0], mid = seg3[half], bIn = seg3[2 * half];
+            this.acP  += mid * bIn  - aOut * mid;
+            this.acRa += mid * mid  - aOut * aOut;
+            this.acRb += bIn * bIn  - mid  * mid;
             this.acScanPos++;
 
-            // If we found a strong peak and metric is dropping, commit
-            if (bestMetric > 0.5 && bestPos >= 0) {
+            // If we found a strong peak and metric is dropping, commit. A
+            // commit on an earlier copy of a repeated preamble is fine: the
+            // refinement steps on to the last one.
+            if (bestMetric > threshold && bestPos >= 0) {
                 if (this.acRa > minEnergy && this.acRb > minEnergy) {
-                    const currentMetric = (this.acP * this.acP) / (this.acRa * this.acRb);
+                    const currentMetric = this.acCombiner.push(this.acScanPos, this.acP, this.acRa, this.acRb, minEnergy);
                     if (currentMetric < bestMetric * 0.7) {
                         // Past the peak
                         this.preambleGlobalPos = bestPos;
@@ -840,7 +1623,7 @@ class StreamingReceiver {
         }
 
         // End of buffer — if we have a candidate, use it
-        if (bestMetric > 0.5 && bestPos >= 0) {
+        if (bestMetric > threshold && bestPos >= 0) {
             this.preambleGlobalPos = bestPos;
             this.state = RECV_STATE.PREAMBLE_DETECTED;
         }
@@ -851,30 +1634,21 @@ class StreamingReceiver {
         const pre1 = this.pre1;
         const pLen = pre1.length;
         const searchRadius = OFDM.CP_LEN * 3;
+        const extra = extraPreambleSamples(); // later copies of a repeated preamble
 
         // Need enough samples for cross-correlation
-        const needed = this.preambleGlobalPos + pLen + searchRadius;
+        const needed = this.preambleGlobalPos + pLen + searchRadius + extra;
         if (rb.totalWritten < needed) return; // wait for more samples
 
         const fineStart = Math.max(rb.totalWritten - rb.capacity, this.preambleGlobalPos - searchRadius);
-        const fineEnd = Math.min(rb.totalWritten - pLen, this.preambleGlobalPos + searchRadius);
-
-        let bestMetric = -Infinity, bestPos = this.preambleGlobalPos;
+        const fineEnd = Math.min(rb.totalWritten - pLen - extra, this.preambleGlobalPos + searchRadius);
 
-        for (let d = fineStart; d <= fineEnd; d++) {
-            const seg = rb.getRange(d, pLen);
-            if (!seg) continue;
-            let corr = 0, sEnergy = 0;
-            for (let i = 0; i < pLen; i++) {
-                corr += seg[i] * pre1[i];
-                sEnergy += seg[i] * seg[i];
-            }
-            const denom = Math.sqrt(sEnergy * this.pre1Energy);
-            if (denom > 0.001) {
-                const metric = corr / denom;
-                if (metric > bestMetric) { bestMetric = metric; bestPos = d; }
-            }
-        }
+        // One copy of the search window instead of one per candidate offset
+        const searchSeg = rb.getRange(fineStart, fineEnd - fineStart + pLen + extra);
+        const refined = searchSeg
+            ? refinePreambleCrossCorr(searchSeg, this.preambleGlobalPos - fineStart, searchRadius, pre1)
+            : { index: 0, metric: -Infinity };
+        const bestMetric = refined.metric, bestPos = fineStart + refined.index;
 
         if (bestMetric < 0.1) {
             // False positive — back to idle
@@ -885,23 +1659,51 @@ class StreamingReceiver {
 
         this.preambleGlobalPos = bestPos;
 
-        // Estimate frame length: we need enough for preamble + CE + some data
-        // We don't know payload size yet, so collect a generous amount
-        // For metadata: ~16 bytes payload
-        // For data: up to chunkSize + 11 bytes overhead
-        const maxPayload = this.metaReceived
-            ? (this.assembler.chunkSize || 4096) + 11
-            : 280; // metadata is small
-        const frameSamples = estimateFrameSamples(maxPayload, this.modName, this.repetition);
-        this.expectedFrameEnd = this.preambleGlobalPos + frameSamples;
+        // The frame header right after CE tells us the exact frame length;
+        // wait until it has arrived.
+        this.headerEnd = this.preambleGlobalPos + (3 + OFDM.numHeaderSymbols()) * OFDM.SYMBOL_LEN + OFDM.CP_LEN;
+        this.expectedFrameEnd = -1;
         this.state = RECV_STATE.COLLECTING_FRAME;
     }
 
     _checkFrameComplete() {
-        if (this.ringBuffer.totalWritten < this.expectedFrameEnd) return;
+        const rb = this.ringBuffer;
+        if (this.expectedFrameEnd < 0) {
+            if (rb.totalWritten < this.headerEnd) return;
+            const hdrSamples = rb.getRange(this.preambleGlobalPos + 2 * OFDM.SYMBOL_LEN,
+                this.headerEnd - this.preambleGlobalPos - 2 * OFDM.SYMBOL_LEN);
+            let header = hdrSamples ? readFrameHeader(hdrSamples) : { error: 'Frame header overwritten' };
+            const frameLen = header.error ? 0
+                : frameSamplesForBits(header.totalBits, frameModulation(header, this.modName), header.mask) - extraPreambleSamples();
+            if (!header.error && (chunkFrameTooLong(header, this.repetition) || frameLen + OFDM.CP_LEN > this.ringBuffer.capacity)) {
+                // Collecting it would miss every real frame until the ring overran
+                header = { error: 'Frame length out of range', reason: DECODE_FAIL.HEADER };
+            }
+            if (header.error) {
+                if (header.reason === DECODE_FAIL.VERSION && !this.versionWarned) {
+                    this.versionWarned = true;
+                    addLog('error', `송신측 프로토콜 버전(${header.version})이 이 빌드(${PROTOCOL_VERSION})보다 새롭습니다 — 수신측 앱을 업데이트하세요`);
+                }
+                // Most likely a false preamble lock — keep scanning right after it
+                this._countFailure(header.reason, null);
+                this.expectedFrameEnd = this.preambleGlobalPos + OFDM.SYMBOL_LEN;
+                this._resetToIdle();
+                return;
+            }
+            // preambleGlobalPos is the last preamble1 copy
+            this.expectedFrameEnd = this.preambleGlobalPos + frameLen + OFDM.CP_LEN;
+        }
+
+        if (rb.totalWritten < this.expectedFrameEnd) return;
 
         this.state = RECV_STATE.DEMODULATING;
-        this._demodulateFrame();
+        this.inFlight = this._demodulateFrame();
+    }
+
+    // Resolves once the frame being demodulated (if any) is stored, so a stop
+    // mid-frame neither closes the database under it nor assembles without it
+    async finish() {
+        await this.inFlight;
     }
 
     async _demodulateFrame() {
@@ -910,7 +1712,10 @@ class StreamingReceiver {
         let frameSamples = rb.getRange(this.preambleGlobalPos, frameLen);
 
         if (!frameSamples) {
+            // Demodulation fell so far behind that the ring buffer wrapped
             this.frameErrors++;
+            this._countFailure(FAIL_OVERRUN, null);
+            addLog('warn', `링 버퍼 오버런 — 프레임 샘플이 덮어써졌습니다 [${FAIL_OVERRUN}]`);
             this._resetToIdle();
             return;
         }
@@ -925,43 +1730,123 @@ class StreamingReceiver {
         }
 
         try {
-            const result = decodeChunkFrame(frameSamples, this.modName, this.repetition);
+            let result = decodeChunkFrame(frameSamples, this.modName, this.repetition);
+            if (result.soft) {
+                // Damaged copy: try it together with earlier damaged copies
+                const combined = this.combiner.combine(result);
+                if (combined) {
+                    this.framesCombined++;
+                    addLog('success', `소프트 결합으로 복구 (사본 ${combined.combinedCopies}개)`);
+                    result = { ...combined, snrDb: result.snrDb, suggestedMask: result.suggestedMask || this.suggestedMask };
+                }
+            }
 
             if (result.error) {
                 this.frameErrors++;
-                addLog('warn', `프레임 복조 실패: ${result.error}`);
+                this._countFailure(result.reason, null);
+                addLog('warn', `프레임 복조 실패 [${result.reason || '?'}]: ${result.error}`);
                 this._resetToIdle();
                 return;
             }
 
-            this.framesDecoded++;
+            // A CRC-valid chunk whose length disagrees with the metadata is
+            // from another transfer. Each frame counts once: decoded if it is
+            // used, an error if not.
+            const wrongLength = result.frameType === FRAME_DATA && result.crcValid && this.assembler.receivedBitmap &&
+                result.seqNum < this.assembler.totalChunks && !this.assembler.chunkLengthValid(result.seqNum, result.dataLen);
+            if (result.crcValid && !wrongLength) this.framesDecoded++;
+            else this.frameErrors++;
+            if (result.suggestedMask !== this.suggestedMask) {
+                this.suggestedMask = result.suggestedMask;
+                logSuggestedMask(result.suggestedMask);
+            }
+            if (result.reverbWarning && !this.reverbWarned) {
+                this.reverbWarned = true;
+                logReverbWarning(result.delaySpread);
+            }
+            if (result.snrDb !== null && result.snrDb !== undefined) {
+                this.snrSum += result.snrDb;
+                this.snrCount++;
+                addLog('debug', `프레임 @${this.preambleGlobalPos}: SNR ${result.snrDb.toFixed(1)} dB, EVM ${result.evm !== null && result.evm !== undefined ? result.evm.toFixed(1) + '%' : '--'}, CRC ${result.crcValid ? '정상' : '오류'}`);
+            }
 
             if (result.frameType === FRAME_META) {
                 if (result.crcValid) {
                     await this.assembler.handleMetadataFrame(result);
+                    this._recordFrame(FRAME_META, null);
                     this.metaReceived = true;
+                    this.rateMeter.add(this.bytesReceived);
                     addLog('success', `메타데이터 수신: ${result.fileName} (${formatSize(result.totalFileSize)}, ${result.totalChunks}개 청크)`);
                     updateStreamingUI(this);
                     const fnEl = document.getElementById('chunk-filename');
                     if (fnEl) fnEl.textContent = `파일: ${result.fileName} (${formatSize(result.totalFileSize)})`;
+                    if (this.assembler.isComplete()) await this._assembleAndDownload(); // 0 chunks
                 } else {
-                    this.frameErrors++;
-                    addLog('error', '메타데이터 CRC 오류');
+                    this._countFailure(DECODE_FAIL.CRC, result.frameType);
+                    addLog('error', `메타데이터 CRC 오류 [${DECODE_FAIL.CRC}]`);
                 }
+            } else if (wrongLength) {
+                this._countFailure(DECODE_FAIL.LENGTH, result.frameType);
+                addLog('warn', `청크 ${result.seqNum + 1} 길이 불일치 (${result.dataLen} B) — 다른 전송의 청크로 보고 버립니다 [${DECODE_FAIL.LENGTH}]`);
             } else if (result.frameType === FRAME_DATA) {
+                const recoveredBefore = this.assembler.recoveredChunks;
                 await this.assembler.handleDataChunk(result.seqNum, result.data, result.crcValid);
                 if (result.crcValid) {
+                    this._recordFrame(FRAME_DATA, null, result.seqNum);
+                    this.bytesReceived += result.dataLen;
+                    this.rateMeter.add(this.bytesReceived);
                     addLog('info', `청크 ${result.seqNum + 1}/${this.assembler.totalChunks} 수신 (${formatSize(result.dataLen)})`);
                 } else {
-                    addLog('warn', `청크 ${result.seqNum + 1} CRC 오류`);
+                    this._countFailure(DECODE_FAIL.CRC, result.frameType);
+                    addLog('warn', `청크 ${result.seqNum + 1} CRC 오류 [${DECODE_FAIL.CRC}, seq=${result.seqNum}]`);
                 }
                 updateStreamingUI(this);
                 drawChunkBitmap(this.assembler);
 
+                if (this.assembler.recoveredChunks > recoveredBefore) {
+                    addLog('success', `패리티로 누락 청크 복구 (누적 ${this.assembler.recoveredChunks}개)`);
+                }
+
                 if (this.assembler.isComplete()) {
                     addLog('success', '모든 청크 수신 완료! 파일을 조립합니다...');
                     await this._assembleAndDownload();
                 }
+            } else if (result.frameType === FRAME_PARITY) {
+                if (result.crcValid) {
+                    const seq = await this.assembler.handleParityFrame(result);
+                    this._recordFrame(FRAME_PARITY, null);
+                    if (seq >= 0) {
+                        addLog('success', `패리티로 청크 ${seq + 1} 복구`);
+                        updateStreamingUI(this);
+                        drawChunkBitmap(this.assembler);
+                        if (this.assembler.isComplete()) {
+                            addLog('success', '모든 청크 수신 완료! 파일을 조립합니다...');
+                            await this._assembleAndDownload();
+                        }
+                    } else {
+                        addLog('debug', `패리티 프레임 수신 (청크 ${result.firstSeq + 1}–${result.firstSeq + result.count})`);
+                    }
+                } else {
+                    this._countFailure(DECODE_FAIL.CRC, result.frameType);
+                    addLog('warn', `패리티 프레임 CRC 오류 [${DECODE_FAIL.CRC}]`);
+                }
+            } else if (result.frameType === FRAME_BEACON) {
+                if (result.crcValid) {
+                    this._recordFrame(FRAME_BEACON, null);
+                    this._handleBeacon(result);
+                } else {
+                    this._countFailure(DECODE_FAIL.CRC, result.frameType);
+                    addLog('debug', `비콘 CRC 오류 [${DECODE_FAIL.CRC}]`);
+                }
+            } else if (result.frameType === FRAME_MESSAGE) {
+                if (result.crcValid) {
+                    this._recordFrame(FRAME_MESSAGE, null);
+                    this.messages.push({ contentType: result.contentType, data: result.data });
+                    logReceivedMessage(result);
+                } else {
+                    this._countFailure(DECODE_FAIL.CRC, result.frameType);
+                    addLog('error', `메시지 CRC 오류 [${DECODE_FAIL.CRC}]`);
+                }
             }
         } catch (err) {
             this.frameErrors++;
@@ -971,19 +1856,131 @@ class StreamingReceiver {
         this._resetToIdle();
     }
 
+    // Goodput (CRC-valid payload bytes/s) next to the raw on-air bit rate,
+    // frame error rate and mean per-frame SNR.
+    getStats() {
+        const elapsed = (Date.now() - this.startTime) / 1000;
+        const attempts = this.framesDecoded + this.frameErrors;
+        const bps = Constellations[this.modName].bps;
+        const rawBitRate = OFDM.numDataSubs() * bps * OFDM.SAMPLE_RATE / OFDM.SYMBOL_LEN;
+        return {
+            elapsed,
+            framesDecoded: this.framesDecoded,
+            frameErrors: this.frameErrors,
+            bytesReceived: this.bytesReceived,
+            goodput: elapsed > 0 ? this.bytesReceived / elapsed : 0,
+            rawBitRate,
+            frameErrorRate: attempts > 0 ? this.frameErrors / attempts : 0,
+            avgSnrDb: this.snrCount > 0 ? this.snrSum / this.snrCount : null,
+            dropouts: this.dropouts,
+            droppedSamples: this.droppedSamples,
+            framesCombined: this.framesCombined,
+            noSignal: this.noSignal,
+            inputRmsDb: this.inputRmsDb,
+            clippedSamples: this.clippedSamples,
+            feedback: this.feedback.howl ? { ...this.feedback.howl } : null,
+            feedbackEvents: this.feedbackEvents,
+            beaconsHeard: [...this.beacons.values()].reduce((n, b) => n + b.heard, 0),
+            frameTypes: JSON.parse(JSON.stringify(this.frameTypes)),
+        };
+    }
+
+    // Oldest first: { time (ms since start), pos (preamble sample), type, ok, reason, seq }
+    getFrameLog() {
+        return this.frameLog.slice();
+    }
+
+    // Called before the block that follows an input gap. A frame being
+    // collected across the gap is lost, so scanning restarts at the gap.
+    reportDropout(numSamples) {
+        this.dropouts++;
+        this.droppedSamples += numSamples;
+        addLog('warn', `입력 누락: ${numSamples} 샘플 (${(numSamples / OFDM.SAMPLE_RATE * 1000).toFixed(0)} ms) — 재전송이 필요할 수 있습니다`);
+        if (this.state === RECV_STATE.PREAMBLE_DETECTED || this.state === RECV_STATE.COLLECTING_FRAME) {
+            this.frameErrors++;
+            this._countFailure(FAIL_OVERRUN, null);
+            this.expectedFrameEnd = this.ringBuffer.totalWritten;
+            this._resetToIdle();
+        }
+    }
+
+    // Beacons from one node count up, so the span since its first beacon
+    // says how many were sent while listening
+    _handleBeacon(result) {
+        let b = this.beacons.get(result.nodeId);
+        if (!b) {
+            b = { first: result.counter, last: result.counter, heard: 0, snrDb: null };
+            this.beacons.set(result.nodeId, b);
+        }
+        b.heard++;
+        b.last = result.counter;
+        b.snrDb = result.snrDb;
+        const sent = ((b.last - b.first) & 0xFFFF) + 1;
+        const snr = b.snrDb !== null && b.snrDb !== undefined ? `SNR ${b.snrDb.toFixed(1)} dB, ` : '';
+        addLog('success', `비콘 수신: 노드 ${formatNodeId(result.nodeId)} — ${snr}${Math.min(b.heard, sent)}/${sent}개 수신`);
+    }
+
+    // frameType is null when the frame was lost before its type byte was read
+    _countFailure(reason, frameType) {
+        const key = reason || 'unknown';
+        this.errorReasons[key] = (this.errorReasons[key] || 0) + 1;
+        this._recordFrame(frameType, key);
+    }
+
+    _recordFrame(frameType, reason, seq) {
+        const type = FRAME_TYPE_NAMES[frameType] || 'unknown';
+        const counts = this.frameTypes[type] || (this.frameTypes[type] = { ok: 0, failed: 0 });
+        if (reason) counts.failed++;
+        else counts.ok++;
+        this.frameLog.push({
+            time: Date.now() - this.startTime,
+            pos: this.preambleGlobalPos,
+            type,
+            ok: !reason,
+            reason: reason || null,
+            seq: seq === undefined ? null : seq,
+        });
+        if (this.frameLog.length > FRAME_LOG_SIZE) this.frameLog.shift();
+    }
+
+    // e.g. "data 10 (failed 2), meta 1"
+    formatFrameTypes() {
+        return Object.entries(this.frameTypes)
+            .map(([k, c]) => `${k} ${c.ok + c.failed}` + (c.failed > 0 ? ` (failed ${c.failed})` : ''))
+            .join(', ');
+    }
+
+    // e.g. "crc 3, header 1" — header/frame-type failures on every frame
+    // usually mean the two sides use different modulation settings.
+    formatFailureReasons() {
+        return Object.entries(this.errorReasons).map(([k, v]) => `${k} ${v}`).join(', ');
+    }
+
     _resetToIdle() {
         // Resume scanning after current frame
-        this.acScanPos = this.expectedFrameEnd || (this.preambleGlobalPos + OFDM.SYMBOL_LEN);
+        this.acScanPos = this.expectedFrameEnd > 0 ? this.expectedFrameEnd : this.preambleGlobalPos + OFDM.SYMBOL_LEN;
         this.acInitialized = false;
         this.preambleGlobalPos = -1;
+        this.headerEnd = -1;
         this.expectedFrameEnd = -1;
         this.state = RECV_STATE.IDLE;
     }
 
-    async _assembleAndDownload() {
+    // partial: chunks are missing (zero-filled), so the name gets a .partial
+    // suffix rather than passing for the real file
+    async _assembleAndDownload(partial = false) {
         try {
             const fileData = await this.assembler.assembleFile();
-            const fileName = this.assembler.fileName || 'received_file';
+            let fileName = (this.assembler.fileName || 'received_file') + (partial ? '.partial' : '');
+            const hash = this.assembler.fileHash;
+            if (hash && !partial) {
+                if (hashesEqual(hashBytes(hash.alg, fileData), hash.digest)) {
+                    addLog('success', `${FILE_HASH_NAMES[hash.alg]} 확인: 파일 전체 일치`);
+                } else {
+                    addLog('error', `${FILE_HASH_NAMES[hash.alg]} 불일치 — 청크 CRC는 통과했지만 파일이 원본과 다릅니다`);
+                    fileName += '.corrupted';
+                }
+            }
             addLog('success', `파일 조립 완료: ${fileName} (${formatSize(fileData.length)})`);
             updateProgress(1.0, `수신 완료: ${fileName}`);
             offerDownload(fileData, fileName);
@@ -1008,15 +2005,20 @@ function updateStreamingUI(receiver) {
     const etaEl = document.getElementById('chunk-eta');
 
     if (countEl) countEl.textContent = `${asm.receivedCount} / ${asm.totalChunks} 청크`;
-    if (errEl) errEl.textContent = `오류: ${asm.crcErrors + receiver.frameErrors}`;
+    if (errEl) errEl.textContent = `오류: ${receiver.frameErrors}`;
 
-    // ETA estimation
-    if (asm.receivedCount > 0) {
-        const elapsed = (Date.now() - receiver.startTime) / 1000;
-        const rate = asm.receivedCount / elapsed;
-        const remaining = (asm.totalChunks - asm.receivedCount) / rate;
-        if (etaEl) etaEl.textContent = `남은 시간: ${formatETA(remaining)}`;
-    }
+    // ETA from the instantaneous rate (recent chunks only)
+    const rate = receiver.rateMeter.rate();
+    const remainingBytes = Math.max(0, asm.totalFileSize - asm.receivedCount * asm.chunkSize);
+    if (etaEl) etaEl.textContent = `남은 시간: ${formatETA(receiver.rateMeter.eta(remainingBytes))} · ${formatRate(rate)}`;
+
+    const stats = receiver.getStats();
+    const goodputEl = document.getElementById('chunk-goodput');
+    const ferEl = document.getElementById('chunk-fer');
+    const snrEl = document.getElementById('chunk-snr');
+    if (goodputEl) goodputEl.textContent = `실효 속도: ${formatSize(Math.round(stats.goodput))}/s (원시 ${formatSize(Math.round(stats.rawBitRate / 8))}/s)`;
+    if (ferEl) ferEl.textContent = `FER: ${(stats.frameErrorRate * 100).toFixed(1)}%` + (stats.dropouts > 0 ? ` · 누락 ${stats.dropouts}회` : '');
+    if (snrEl) snrEl.textContent = stats.avgSnrDb !== null ? `SNR: ${stats.avgSnrDb.toFixed(1)} dB` : 'SNR: --';
 
     const progress = asm.totalChunks > 0 ? asm.receivedCount / asm.totalChunks : 0;
     updateProgress(progress, `청크 ${asm.receivedCount}/${asm.totalChunks} 수신 · 오류: ${asm.crcErrors}`);
@@ -1045,6 +2047,8 @@ function drawChunkBitmap(assembler) {
         const x = (i / total) * w;
         if (assembler.isReceived(i)) {
             ctx.fillStyle = '#00ff88'; // received
+        } else if (assembler.crcFailedSeqs.has(i)) {
+            ctx.fillStyle = '#ff4444'; // heard, CRC failed
         } else {
             ctx.fillStyle = '#333';    // not yet
         }
@@ -1055,6 +2059,49 @@ function drawChunkBitmap(assembler) {
 // --- Streaming Receive Start/Stop ---
 
 let isStreamingReceive = false;
+let streamingUITimer = null;
+let streamingCapture = null; // InputCapture while "수신 녹음 저장" is on
+
+// Raw input of a streaming receive (after resampling, as the receiver saw
+// it), saved as WAV on stop so a failed transfer can be replayed through
+// WAV 파일 열기. Past the 최대 녹음 length the oldest blocks are dropped,
+// so a long session keeps only its last minutes.
+class InputCapture {
+    constructor(maxSeconds) {
+        this.maxSamples = Math.round(maxSeconds * OFDM.SAMPLE_RATE);
+        this.blocks = [];
+        this.length = 0;
+        this.droppedSamples = 0;
+    }
+
+    append(samples) {
+        this.blocks.push(new Float32Array(samples));
+        this.length += samples.length;
+        while (this.length - this.blocks[0].length >= this.maxSamples) {
+            const old = this.blocks.shift();
+            this.length -= old.length;
+            this.droppedSamples += old.length;
+        }
+    }
+
+    toWAV() {
+        const signal = new Float32Array(this.length);
+        let off = 0;
+        for (const b of this.blocks) { signal.set(b, off); off += b.length; }
+        return encodeWAV(signal, OFDM.SAMPLE_RATE);
+    }
+}
+
+function saveInputCapture(capture) {
+    if (capture.length === 0) return;
+    const stamp = new Date().toISOString().replace(/[-:]/g, '').replace('T', '-').slice(0, 15);
+    const wav = capture.toWAV();
+    downloadBlob(wav, `capture-${stamp}.wav`, 'audio/wav');
+    addLog('info', `수신 녹음 저장: ${(capture.length / OFDM.SAMPLE_RATE).toFixed(1)}초 (${formatSize(wav.length)})`);
+    if (capture.droppedSamples > 0) {
+        addLog('warn', `최대 녹음 길이를 넘어 앞부분 ${(capture.droppedSamples / OFDM.SAMPLE_RATE).toFixed(0)}초는 저장하지 않았습니다`);
+    }
+}
 
 async function startStreamingReceive() {
     const btn = document.getElementById('btn-receive');
@@ -1063,6 +2110,10 @@ async function startStreamingReceive() {
         stopStreamingReceive();
         return;
     }
+    if (isReplaying) {
+        addLog('warn', 'WAV 재생이 끝난 뒤에 수신을 시작하세요');
+        return;
+    }
 
     try {
         micStream = await navigator.mediaDevices.getUserMedia({
@@ -1089,8 +2140,10 @@ async function startStreamingReceive() {
     setOFDMConfig(config);
 
     streamingReceiver = new StreamingReceiver(modName, repetition);
+    streamingCapture = document.getElementById('save-capture').value === '1' ? new InputCapture(getMaxDuration()) : null;
 
     const ctx = getAudioContext();
+    checkDeviceBand(ctx);
     const source = ctx.createMediaStreamSource(micStream);
 
     levelAnalyser = ctx.createAnalyser();
@@ -1105,10 +2158,18 @@ async function startStreamingReceive() {
     drawLevelMeter(levelAnalyser, levelCanvas);
 
     const processor = ctx.createScriptProcessor(4096, 1, 1);
+    const resampler = createInputResampler(ctx);
+    const detectDropout = createDropoutDetector(ctx, 4096);
     processor.onaudioprocess = (e) => {
         if (!isStreamingReceive) return;
-        const input = e.inputBuffer.getChannelData(0);
-        streamingReceiver.processAudioBlock(input);
+        const lost = detectDropout(e);
+        if (lost > 0) streamingReceiver.reportDropout(lost);
+        const gated = isInputGated(ctx);
+        const input = gated ? new Float32Array(e.inputBuffer.length) : applyInputGain(e.inputBuffer.getChannelData(0));
+        const block = resampler ? resampler.process(input) : input;
+        if (streamingCapture) streamingCapture.append(block);
+        streamingReceiver.processAudioBlock(block);
+        if (gated) streamingReceiver.resetSilence();
     };
 
     levelAnalyser.connect(processor);
@@ -1121,6 +2182,11 @@ async function startStreamingReceive() {
     const chunkPanel = document.getElementById('chunk-progress');
     if (chunkPanel) chunkPanel.style.display = 'block';
 
+    // Refresh rate/ETA between frames so a stall is visible
+    streamingUITimer = setInterval(() => {
+        if (streamingReceiver && streamingReceiver.metaReceived) updateStreamingUI(streamingReceiver);
+    }, 1000);
+
     addLog('info', '스트리밍 수신 시작 — 실시간 프레임 탐지 활성화');
 }
 
@@ -1128,6 +2194,7 @@ function stopStreamingReceive() {
     isStreamingReceive = false;
     isRecording = false;
     levelAnalyser = null;
+    if (streamingUITimer) { clearInterval(streamingUITimer); streamingUITimer = null; }
 
     const btn = document.getElementById('btn-receive');
     btn.textContent = '수신 대기';
@@ -1139,27 +2206,42 @@ function stopStreamingReceive() {
     if (btn._source) { btn._source.disconnect(); btn._source = null; }
     if (micStream) { micStream.getTracks().forEach(t => t.stop()); micStream = null; }
 
+    if (streamingCapture) {
+        saveInputCapture(streamingCapture);
+        streamingCapture = null;
+    }
     if (streamingReceiver) {
-        const asm = streamingReceiver.assembler;
-        if (asm.totalChunks > 0 && !asm.isComplete()) {
-            const missing = asm.getMissingChunks();
-            addLog('warn', `수신 중지: ${asm.receivedCount}/${asm.totalChunks} 청크 수신, ${missing.length}개 누락`);
-            if (asm.receivedCount > 0) {
-                addLog('info', '수신된 청크로 부분 파일을 조립합니다...');
-                streamingReceiver._assembleAndDownload().then(() => {
-                    streamingReceiver.cleanup();
-                    streamingReceiver = null;
-                });
-                return;
-            }
-        } else if (asm.isComplete()) {
-            addLog('success', '모든 청크 수신 완료');
-        }
-        streamingReceiver.cleanup();
+        finishStreamingReceive(streamingReceiver);
         streamingReceiver = null;
     }
 }
 
+// Summary and partial-file assembly once the last frame has settled
+async function finishStreamingReceive(receiver) {
+    await receiver.finish();
+    const asm = receiver.assembler;
+    const reasons = receiver.formatFailureReasons();
+    const types = receiver.formatFrameTypes();
+    if (types) addLog('info', `프레임 종류별: ${types}`);
+    if (reasons) addLog('info', `복조 실패 원인: ${reasons}`);
+    if (receiver.dropouts > 0) {
+        addLog('warn', `입력 누락 ${receiver.dropouts}회 (총 ${(receiver.droppedSamples / OFDM.SAMPLE_RATE * 1000).toFixed(0)} ms)`);
+    }
+    if (asm.totalChunks > 0 && !asm.isComplete()) {
+        const missing = asm.getMissingChunks();
+        const corrupted = asm.getCorruptedChunks();
+        addLog('warn', `수신 중지: ${asm.receivedCount}/${asm.totalChunks} 청크 수신, ${missing.length}개 누락 ` +
+            `(CRC 오류 ${corrupted.length}개, 미수신 ${missing.length - corrupted.length}개)`);
+        if (asm.receivedCount > 0) {
+            addLog('info', '수신된 청크로 부분 파일을 조립합니다 (누락 구간은 0으로 채움)...');
+            await receiver._assembleAndDownload(true);
+        }
+    } else if (asm.isComplete()) {
+        addLog('success', '모든 청크 수신 완료');
+    }
+    receiver.cleanup();
+}
+
 // --- Progress ---
 function showProgress() {
     document.getElementById('progress-panel').style.display = 'block';
@@ -1173,7 +2255,12 @@ function updateProgress(ratio, message) {
 }
 
 // --- Log ---
+// 'debug' entries (per-frame detail) are hidden unless the log level allows them
+const LOG_LEVELS = { debug: 0, info: 1, success: 1, warn: 2, error: 3 };
+let logLevel = 'info';
+
 function addLog(level, message) {
+    if (LOG_LEVELS[level] < LOG_LEVELS[logLevel]) return;
     const container = document.getElementById('log-container');
     const entry = document.createElement('div');
     entry.className = `log-entry ${level}`;
@@ -1342,7 +2429,7 @@ async function runOutputTest() {
 
         // 1. Sweep tone (1kHz → 10kHz, 2s)
         const sweep = generateSweepTone(1000, 10000, 2.0, sr);
-        await playSignalAsync(ctx, sweep);
+        await playSignalAsync(ctx, sweep, sr);
         addLog('info', '스윕 톤 완료 — OFDM 테스트 심볼 재생');
 
         // 2. OFDM test symbol (preamble + CE)
@@ -1366,7 +2453,7 @@ async function runInputTest() {
     setTestButtonsDisabled(true);
     hideTestResults();
 
-    addLog('info', '입력 테스트 시작 — 3초간 녹음');
+    addLog('info', '입력 테스트 시작 — 3초간 녹음 (송신측에서 출력 테스트를 재생하면 실제 신호 레벨로 보정됩니다)');
 
     let stream;
     try {
@@ -1402,7 +2489,7 @@ async function runInputTest() {
 
             processor.onaudioprocess = (e) => {
                 const input = e.inputBuffer.getChannelData(0);
-                chunks.push(new Float32Array(input));
+                chunks.push(applyInputGain(input));
                 totalSamples += input.length;
                 if (totalSamples >= maxSamples) {
                     clearTimeout(timeout);
@@ -1431,15 +2518,8 @@ async function runInputTest() {
         for (const c of chunks) { recorded.set(c, off); off += c.length; }
 
         // Analyze
-        let sumSq = 0, peak = 0;
-        for (let i = 0; i < recorded.length; i++) {
-            const v = Math.abs(recorded[i]);
-            sumSq += recorded[i] * recorded[i];
-            if (v > peak) peak = v;
-        }
-        const rms = Math.sqrt(sumSq / recorded.length);
-        const rmsDb = rms > 0 ? 20 * Math.log10(rms) : -Infinity;
-        const peakDb = peak > 0 ? 20 * Math.log10(peak) : -Infinity;
+        const input = classifyInputLevel(recorded);
+        const { rms, rmsDb, peakDb } = input;
 
         // Noise floor: average RMS of bottom 10% blocks
         const blockSize = 1024;
@@ -1462,10 +2542,9 @@ async function runInputTest() {
         const fftSize = 2048;
         const specLen = Math.min(recorded.length, fftSize);
         const fftRe = new Float64Array(fftSize);
-        const fftIm = new Float64Array(fftSize);
         const midStart = Math.max(0, Math.floor((recorded.length - fftSize) / 2));
         for (let i = 0; i < specLen; i++) fftRe[i] = recorded[midStart + i];
-        const [specRe, specIm] = fft(fftRe, fftIm);
+        const [specRe, specIm] = rfft(fftRe);
 
         const magnitudes = new Float32Array(fftSize / 2);
         for (let i = 0; i < fftSize / 2; i++) {
@@ -1475,18 +2554,19 @@ async function runInputTest() {
         // Draw spectrum
         const canvas = document.getElementById('test-spectrum-canvas');
         canvas.style.display = 'block';
-        drawSpectrum(canvas, magnitudes);
+        drawSpectrum(canvas, magnitudes, sr);
 
         // Assessment
-        const clipping = peak > 0.95;
-        const lowLevel = rms < 0.005;
         let quality, message;
-        if (clipping) {
+        if (input.level === 'clipping') {
             quality = 'poor';
-            message = `클리핑 감지! 볼륨을 낮춰주세요.\nRMS: ${rmsDb.toFixed(1)} dB · 피크: ${peakDb.toFixed(1)} dB · 노이즈: ${noiseDb.toFixed(1)} dB`;
-        } else if (lowLevel) {
+            message = `클리핑 감지! (${input.clippedSamples} 샘플이 ±1.0에 닿음) 볼륨을 낮춰주세요.\nRMS: ${rmsDb.toFixed(1)} dB · 피크: ${peakDb.toFixed(1)} dB · 노이즈: ${noiseDb.toFixed(1)} dB`;
+        } else if (input.level === 'loud') {
+            quality = 'good';
+            message = `입력이 큽니다 — 클리핑은 없지만 여유가 적으니 볼륨을 조금 낮추면 안전합니다.\nRMS: ${rmsDb.toFixed(1)} dB · 피크: ${peakDb.toFixed(1)} dB · 노이즈: ${noiseDb.toFixed(1)} dB`;
+        } else if (input.level === 'low') {
             quality = 'poor';
-            message = `입력 레벨이 너무 낮습니다. 볼륨을 높이거나 마이크를 확인하세요.\nRMS: ${rmsDb.toFixed(1)} dB · 피크: ${peakDb.toFixed(1)} dB · 노이즈: ${noiseDb.toFixed(1)} dB`;
+            message = `입력 레벨이 너무 낮습니다. 볼륨(또는 설정의 입력 게인)을 높이거나 마이크를 확인하세요.\nRMS: ${rmsDb.toFixed(1)} dB · 피크: ${peakDb.toFixed(1)} dB · 노이즈: ${noiseDb.toFixed(1)} dB`;
         } else {
             quality = rms > 0.02 ? 'excellent' : 'good';
             message = `입력 감도 양호\nRMS: ${rmsDb.toFixed(1)} dB · 피크: ${peakDb.toFixed(1)} dB · 노이즈: ${noiseDb.toFixed(1)} dB`;
@@ -1541,10 +2621,12 @@ async function runLoopbackTest() {
         const chunks = [];
         let totalSamples = 0;
         let recording = true;
+        let inputStartTime = null; // AudioContext time of the first recorded sample
 
         processor.onaudioprocess = (e) => {
             if (!recording) return;
             const input = e.inputBuffer.getChannelData(0);
+            if (inputStartTime === null) inputStartTime = ctx.currentTime - input.length / sr;
             chunks.push(new Float32Array(input));
             totalSamples += input.length;
         };
@@ -1556,6 +2638,7 @@ async function runLoopbackTest() {
 
         // Generate and play test signal
         const { signal: testSignal, testData } = generateTestSignal(modName, repetition);
+        const playTime = ctx.currentTime;
         await playSignalAsync(ctx, testSignal);
 
         // Wait 1 second after playback
@@ -1575,15 +2658,17 @@ async function runLoopbackTest() {
             return;
         }
 
-        // Concatenate
-        const recorded = new Float32Array(totalSamples);
+        // Concatenate (and bring to the modem rate if the device runs at another)
+        let recorded = new Float32Array(totalSamples);
         let off = 0;
         for (const c of chunks) { recorded.set(c, off); off += c.length; }
+        recorded = resample(recorded, sr, OFDM.SAMPLE_RATE);
 
         addLog('info', `녹음 완료: ${(totalSamples / sr).toFixed(1)}초 — 분석 중...`);
 
         // Analyze
-        const result = analyzeLoopback(recorded, modName, repetition, testData);
+        const playStartIdx = inputStartTime === null ? null : Math.round((playTime - inputStartTime) * OFDM.SAMPLE_RATE);
+        const result = analyzeLoopback(recorded, modName, repetition, testData, playStartIdx);
 
         // Draw channel response if available
         if (result.channelMagnitude.length > 0) {
@@ -1609,12 +2694,16 @@ async function runLoopbackTest() {
             `상관 피크: ${corrPct}%`,
             `BER: ${berPct}%`,
             `SNR 추정: ${isFinite(result.snrEstimate) ? result.snrEstimate.toFixed(1) + ' dB' : 'N/A'}`,
+            `지연 확산: ${formatDelaySpread(result.delaySpread)}`,
+            `왕복 지연: ${result.latencyMs !== null ? result.latencyMs.toFixed(0) + ' ms' : '측정 불가 (신호 미탐지)'}`,
             `권장 변조: ${recommendedMod}`,
             `(샘플레이트: ${sr} Hz)`,
         ].join('\n');
 
+        if (result.reverbWarning) logReverbWarning(result.delaySpread);
         addLog(result.quality === 'poor' ? 'warn' : 'success',
-            `루프백 테스트: 상관=${corrPct}%, BER=${berPct}%, 품질=${result.quality}`);
+            `루프백 테스트: 상관=${corrPct}%, BER=${berPct}%, 품질=${result.quality}` +
+            (result.latencyMs !== null ? `, 지연=${result.latencyMs.toFixed(0)}ms` : ''));
         showTestResult('루프백 테스트 결과', message, result.quality);
     } catch (err) {
         addLog('error', `루프백 테스트 오류: ${err.message}`);
@@ -1626,9 +2715,17 @@ async function runLoopbackTest() {
     setTestButtonsDisabled(false);
 }
 
+// Delay spread in ms against the cyclic prefix it has to fit in
+function formatDelaySpread(spread) {
+    if (!spread) return 'N/A';
+    const ms = n => (n / OFDM.SAMPLE_RATE * 1000).toFixed(2);
+    return `RMS ${ms(spread.rms)} ms, 최대 ${ms(spread.maxExcess)} ms (CP ${ms(OFDM.CP_LEN)} ms)`;
+}
+
 // --- Visualization Helpers ---
 
-function drawSpectrum(canvas, magnitudes) {
+// magnitudes: bins 0..N/2-1 of an N-point FFT of audio at sampleRate
+function drawSpectrum(canvas, magnitudes, sampleRate) {
     const dpr = window.devicePixelRatio || 1;
     canvas.width = canvas.clientWidth * dpr;
     canvas.height = 100 * dpr;
@@ -1649,12 +2746,12 @@ function drawSpectrum(canvas, magnitudes) {
     }
     const minDb = maxDb - 80;
 
-    // OFDM band highlight
-    const freqPerBin = 44100 / (magnitudes.length * 2);
-    const bandStartHz = OFDM.SUB_START * freqPerBin;
-    const bandEndHz = OFDM.SUB_END * freqPerBin;
-    const xBandStart = (bandStartHz / 22050) * w;
-    const xBandEnd = (bandEndHz / 22050) * w;
+    // OFDM band highlight (band edges are modem FFT bins, not display bins)
+    const nyquist = sampleRate / 2;
+    const bandStartHz = OFDM.SUB_START * OFDM.SAMPLE_RATE / OFDM.FFT_SIZE;
+    const bandEndHz = OFDM.SUB_END * OFDM.SAMPLE_RATE / OFDM.FFT_SIZE;
+    const xBandStart = (bandStartHz / nyquist) * w;
+    const xBandEnd = (bandEndHz / nyquist) * w;
     ctx.fillStyle = 'rgba(0,212,255,0.08)';
     ctx.fillRect(xBandStart, 0, xBandEnd - xBandStart, h);
 
@@ -1675,8 +2772,8 @@ function drawSpectrum(canvas, magnitudes) {
     ctx.fillStyle = '#666';
     ctx.font = '10px monospace';
     ctx.fillText('0 kHz', 2, h - 2);
-    ctx.fillText('11 kHz', w / 2 - 20, h - 2);
-    ctx.fillText('22 kHz', w - 36, h - 2);
+    ctx.fillText(`${(nyquist / 2000).toFixed(0)} kHz`, w / 2 - 20, h - 2);
+    ctx.fillText(`${(nyquist / 1000).toFixed(0)} kHz`, w - 36, h - 2);
 }
 
 function drawChannelResponse(canvas, channelMag) {
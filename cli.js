@@ -0,0 +1,89 @@
+#!/usr/bin/env node
+// Headless send/receive through WAV files, for scripting and CI:
+//   node cli.js send <file> <out.wav> [--mode QPSK] [--hash crc32c|sha256]
+//   node cli.js receive <in.wav> [outDir] [--mode QPSK]
+// Modes and framing are the app's (see README); --hash always sends chunk frames.
+const fs = require('fs');
+const path = require('path');
+const { Modem, getModemParams, CHUNK_THRESHOLD, encodeWAV, decodeWAV, resample, sanitizeFileName, uniqueFileName, FILE_HASH, setFileHash, MFSKModem, FRAME_MESSAGE, DECODE_FAIL } = require('./modem.js');
+
+function parseArgs(argv) {
+    const args = [];
+    let mode = 'QPSK', hash = null;
+    for (let i = 0; i < argv.length; i++) {
+        if (argv[i] === '--mode') mode = argv[++i];
+        else if (argv[i] === '--hash') hash = argv[++i];
+        else args.push(argv[i]);
+    }
+    return { args, mode, hash };
+}
+
+function createModem(mode) {
+    if (mode === 'MFSK') return new MFSKModem();
+    const { config, modName, repetition } = getModemParams(mode);
+    return new Modem(config, modName, repetition);
+}
+
+// "name (1).ext" style, so an earlier received file is never overwritten
+function uniquePath(dir, name) {
+    return path.join(dir, uniqueFileName(name, n => fs.existsSync(path.join(dir, n))));
+}
+
+function send(file, out, mode, hashed) {
+    const data = new Uint8Array(fs.readFileSync(file));
+    const name = path.basename(file);
+    const modem = createModem(mode);
+    if (hashed && !modem.encodeFile) { console.error(`--hash needs chunk frames, which ${mode} does not send`); return 2; }
+    const chunked = modem.encodeFile && (data.length > CHUNK_THRESHOLD || hashed);
+    const signal = chunked ? modem.encodeFile(data, name) : modem.encode(data, name);
+    if (signal.error) { console.error(`${file}: ${signal.error}`); return 1; }
+    fs.writeFileSync(out, encodeWAV(signal, modem.sampleRate));
+    console.log(`${name}: ${data.length} bytes → ${out} (${(signal.length / modem.sampleRate).toFixed(1)} s, ${mode})`);
+    return 0;
+}
+
+function receive(wavFile, dir, mode) {
+    const wav = decodeWAV(new Uint8Array(fs.readFileSync(wavFile)));
+    if (wav.error) { console.error(`${wavFile}: ${wav.error}`); return 1; }
+    const modem = createModem(mode);
+    const samples = resample(wav.samples, wav.sampleRate, modem.sampleRate);
+
+    let result = modem.decode(samples);
+    // A message is printed, not saved
+    if (result.frameType === FRAME_MESSAGE) {
+        const msg = modem.decodeMessage(samples);
+        if (!msg.error) {
+            console.log(`${wavFile}: ${msg.contentType} message (${msg.data.length} bytes)`);
+            if (/^text\//.test(msg.contentType)) console.log(new TextDecoder().decode(msg.data));
+            return 0;
+        }
+        result = msg;
+    } else if (result.reason === DECODE_FAIL.FRAME_TYPE) {
+        result = modem.decodeFile(samples);
+    }
+    if (result.error) {
+        console.error(`${wavFile}: ${result.error}${result.missing ? ` (missing: ${result.missing.join(', ')})` : ''}`);
+        return 1;
+    }
+    fs.mkdirSync(dir, { recursive: true });
+    const out = uniquePath(dir, sanitizeFileName(result.fileName) || 'received.bin');
+    fs.writeFileSync(out, result.data);
+    console.log(`${wavFile} → ${out} (${result.data.length} bytes)`);
+    return 0;
+}
+
+function main(argv) {
+    const [command, ...rest] = argv;
+    const { args, mode, hash } = parseArgs(rest);
+    const alg = hash === null ? FILE_HASH.NONE : { crc32c: FILE_HASH.CRC32C, sha256: FILE_HASH.SHA256 }[hash];
+    if (alg === undefined) { console.error(`unknown --hash ${hash} (crc32c or sha256)`); return 2; }
+    setFileHash(alg);
+    if (command === 'send' && args.length === 2) return send(args[0], args[1], mode, alg !== FILE_HASH.NONE);
+    if (command === 'receive' && args.length >= 1) return receive(args[0], args[1] || '.', mode);
+    console.error('usage: node cli.js send <file> <out.wav> [--mode QPSK] [--hash crc32c|sha256]\n       node cli.js receive <in.wav> [outDir] [--mode QPSK]');
+    return 2;
+}
+
+if (require.main === module) process.exitCode = main(process.argv.slice(2));
+
+module.exports = { main };
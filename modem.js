@@ -23,35 +23,125 @@ function ifft(re, im) {
     return [outRe, outIm];
 }
 
+// Twiddles e^{-2πij/n} for j < n/2, computed once per FFT size. A stage of
+// size m uses every (n/m)-th entry, so one table serves all stages, and
+// each factor is exact rather than accumulated by repeated multiplication.
+const fftTwiddles = new Map();
+
+function fftTwiddle(n) {
+    let w = fftTwiddles.get(n);
+    if (!w) {
+        w = { re: new Float64Array(n >> 1), im: new Float64Array(n >> 1) };
+        for (let j = 0; j < n >> 1; j++) {
+            w.re[j] = Math.cos(2 * Math.PI * j / n);
+            w.im[j] = -Math.sin(2 * Math.PI * j / n);
+        }
+        fftTwiddles.set(n, w);
+    }
+    return w;
+}
+
 function fftIterative(re, im, inverse) {
     const n = re.length;
+    const tw = fftTwiddle(n);
+    const sign = inverse ? -1 : 1;
     for (let size = 2; size <= n; size <<= 1) {
         const half = size >> 1;
-        const sign = inverse ? 1 : -1;
-        const angle = sign * 2 * Math.PI / size;
-        const wnRe = Math.cos(angle), wnIm = Math.sin(angle);
+        const stride = n / size;
         for (let start = 0; start < n; start += size) {
-            let wRe = 1, wIm = 0;
             for (let j = 0; j < half; j++) {
+                const wRe = tw.re[j * stride], wIm = sign * tw.im[j * stride];
                 const i1 = start + j, i2 = start + j + half;
                 const tRe = wRe * re[i2] - wIm * im[i2];
                 const tIm = wRe * im[i2] + wIm * re[i2];
                 re[i2] = re[i1] - tRe; im[i2] = im[i1] - tIm;
                 re[i1] += tRe; im[i1] += tIm;
-                const nwRe = wRe * wnRe - wIm * wnIm;
-                wIm = wRe * wnIm + wIm * wnRe;
-                wRe = nwRe;
             }
         }
     }
 }
 
+// --- Real-Input FFT ---
+// A real signal packed into an n/2-point complex FFT (even → re, odd → im)
+// and split; spectra are full n-bin arrays, indexed like fft()'s.
+// rfft is unnormalized and irfft divides by n: received bins are n times the
+// sent points, a scale nothing downstream depends on.
+// Per size: split twiddles and packed scratch (neither function re-enters).
+const rfftPlans = new Map();
+
+function rfftPlan(n) {
+    let w = rfftPlans.get(n);
+    if (!w) {
+        w = { re: new Float64Array(n / 2), im: new Float64Array(n / 2),
+              zRe: new Float64Array(n / 2), zIm: new Float64Array(n / 2) };
+        for (let k = 0; k < n / 2; k++) {
+            w.re[k] = Math.cos(-2 * Math.PI * k / n);
+            w.im[k] = Math.sin(-2 * Math.PI * k / n);
+        }
+        rfftPlans.set(n, w);
+    }
+    return w;
+}
+
+// out: optional [re, im] arrays of length n to fill instead of allocating
+function rfft(x, out) {
+    const n = x.length, h = n >> 1;
+    const w = rfftPlan(n);
+    const zRe = w.zRe, zIm = w.zIm;
+    for (let m = 0; m < h; m++) { zRe[m] = x[2 * m]; zIm[m] = x[2 * m + 1]; }
+    bitReverse(zRe, zIm);
+    fftIterative(zRe, zIm, false);
+
+    const [outRe, outIm] = out || [new Float64Array(n), new Float64Array(n)];
+    for (let k = 0; k <= h; k++) {
+        const a = k % h, b = (h - k) % h;
+        // Even part E = (Z[k] + conj(Z[h-k])) / 2, odd part O = (Z[k] - conj(Z[h-k])) / 2i
+        const eRe = (zRe[a] + zRe[b]) / 2, eIm = (zIm[a] - zIm[b]) / 2;
+        const oRe = (zIm[a] + zIm[b]) / 2, oIm = -(zRe[a] - zRe[b]) / 2;
+        const wRe = k < h ? w.re[k] : -1, wIm = k < h ? w.im[k] : 0;
+        outRe[k] = eRe + wRe * oRe - wIm * oIm;
+        outIm[k] = eIm + wRe * oIm + wIm * oRe;
+    }
+    for (let k = h + 1; k < n; k++) { outRe[k] = outRe[n - k]; outIm[k] = -outIm[n - k]; }
+    return [outRe, outIm];
+}
+
+// Inverse of rfft for a Hermitian spectrum; only bins 0..n/2 are read.
+function irfft(re, im) {
+    const n = re.length, h = n >> 1;
+    const w = rfftPlan(n);
+    const zRe = w.zRe, zIm = w.zIm;
+    for (let k = 0; k < h; k++) {
+        const cRe = re[h - k], cIm = -im[h - k]; // conj(X[h-k]) = X[k+h]
+        const eRe = (re[k] + cRe) / 2, eIm = (im[k] + cIm) / 2;
+        const dRe = (re[k] - cRe) / 2, dIm = (im[k] - cIm) / 2;
+        const oRe = dRe * w.re[k] + dIm * w.im[k]; // O = d · conj(W^k)
+        const oIm = dIm * w.re[k] - dRe * w.im[k];
+        zRe[k] = eRe - oIm; zIm[k] = eIm + oRe;    // Z = E + iO
+    }
+    bitReverse(zRe, zIm);
+    fftIterative(zRe, zIm, true);
+
+    const out = new Float64Array(n);
+    for (let m = 0; m < h; m++) { out[2 * m] = zRe[m] / h; out[2 * m + 1] = zIm[m] / h; }
+    return out;
+}
+
+// Bit-reversal permutation per FFT size, cached alongside the twiddles
+const fftBitReversal = new Map();
+
 function bitReverse(re, im) {
     const n = re.length;
-    let bits = 0, tmp = n;
-    while (tmp > 1) { bits++; tmp >>= 1; }
+    let rev = fftBitReversal.get(n);
+    if (!rev) {
+        let bits = 0, tmp = n;
+        while (tmp > 1) { bits++; tmp >>= 1; }
+        rev = new Uint32Array(n);
+        for (let i = 0; i < n; i++) rev[i] = revBits(i, bits);
+        fftBitReversal.set(n, rev);
+    }
     for (let i = 0; i < n; i++) {
-        const j = revBits(i, bits);
+        const j = rev[i];
         if (i < j) {
             let t = re[i]; re[i] = re[j]; re[j] = t;
             t = im[i]; im[i] = im[j]; im[j] = t;
@@ -71,37 +161,227 @@ const OFDM_CONFIGS = {
         FFT_SIZE: 512, CP_LEN: 64, SYMBOL_LEN: 576, SAMPLE_RATE: 44100,
         SUB_START: 12, SUB_END: 232,
         PILOTS: [15, 29, 43, 57, 71, 85, 99, 113, 127, 141, 155, 169, 183, 197, 211, 225],
+        SYNC_INTERVAL: 64,
     },
     acoustic: {
         FFT_SIZE: 512, CP_LEN: 128, SYMBOL_LEN: 640, SAMPLE_RATE: 44100,
         SUB_START: 23, SUB_END: 93,   // ~2000Hz–8000Hz (스피커/마이크 안정 대역)
         PILOTS: [25, 35, 45, 55, 65, 75, 85],
+        SYNC_INTERVAL: 32,
     },
     narrowband: {
         FFT_SIZE: 512, CP_LEN: 256, SYMBOL_LEN: 768, SAMPLE_RATE: 44100,
         SUB_START: 35, SUB_END: 58,   // ~3000Hz–5000Hz (가장 안정적인 대역)
         PILOTS: [37, 45, 53],
+        SYNC_INTERVAL: 32,
+    },
+    // Near-ultrasonic, inaudible to most adults. Speakers and mics roll off
+    // towards 20 kHz; the receiver's suggested subcarrier mask shows which
+    // top groups to turn off.
+    ultrasonic: {
+        FFT_SIZE: 512, CP_LEN: 128, SYMBOL_LEN: 640, SAMPLE_RATE: 44100,
+        SUB_START: 198, SUB_END: 232, // ~17050Hz–19980Hz
+        PILOTS: [202, 211, 219, 228],
+        SYNC_INTERVAL: 32,
     },
 };
 
 const OFDM = { ...OFDM_CONFIGS.standard };
+OFDM.PILOT_AMP = pilotAmplitudes(OFDM); // set again by setOFDMConfig
 OFDM.isPilot = (k) => OFDM.PILOTS.includes(k);
+// FFT window starts a quarter CP early so sample clock drift in either
+// direction stays inside the prefix; the fixed phase ramp this adds is the
+// same for CE and data symbols and cancels in equalization.
+OFDM.fftWindowStart = () => OFDM.CP_LEN - (OFDM.CP_LEN >> 2);
+OFDM.numHeaderSymbols = () => Math.ceil(FRAME_HEADER_COPIES * FRAME_HEADER_BITS / OFDM.numDataSubs());
 OFDM.numDataSubs = () => {
     let c = 0;
     for (let k = OFDM.SUB_START; k <= OFDM.SUB_END; k++) if (!OFDM.isPilot(k)) c++;
     return c;
 };
+// Data subcarrier indices enabled by a subcarrier mask (see SUB_GROUPS),
+// around the given pilots
+OFDM.dataSubcarriers = (mask = OFDM.subMask, pilots = OFDM.PILOTS) => {
+    const all = [];
+    for (let k = OFDM.SUB_START; k <= OFDM.SUB_END; k++) if (!pilots.includes(k)) all.push(k);
+    return all.filter((k, di) => (mask >> Math.floor(di * SUB_GROUPS / all.length)) & 1);
+};
+// Scattered pilots (PILOT_SHIFTS > 1, DVB-T style): data symbol s moves the
+// grid up by s mod PILOT_SHIFTS steps of 1/PILOT_SHIFTS of the pilot spacing.
+OFDM.pilotsForSymbol = (s) => {
+    const shifts = OFDM.PILOT_SHIFTS || 1;
+    if (shifts <= 1) return OFDM.PILOTS;
+    const n = OFDM.SUB_END - OFDM.SUB_START + 1;
+    const offset = Math.round((s % shifts) * n / OFDM.PILOTS.length / shifts);
+    return OFDM.PILOTS.map(p => OFDM.SUB_START + (p - OFDM.SUB_START + offset) % n).sort((a, b) => a - b);
+};
+// Re-sync symbols (a copy of the CE symbol) sent after every SYNC_INTERVAL
+// data symbols; 0 disables them. None follows the last data symbol.
+OFDM.numSyncSymbols = (numDataSymbols) =>
+    OFDM.SYNC_INTERVAL > 0 && numDataSymbols > 0 ? Math.floor((numDataSymbols - 1) / OFDM.SYNC_INTERVAL) : 0;
+
+// Subcarrier mask: bit g enables the g-th of SUB_GROUPS contiguous groups of
+// data subcarriers (bit 0 = lowest). Sent in the frame header; sender-only.
+const SUB_GROUPS = 16;
+const FULL_SUB_MASK = 0xFFFF;
+OFDM.subMask = FULL_SUB_MASK;
+
+function setSubcarrierMask(mask) {
+    OFDM.subMask = (mask & FULL_SUB_MASK) || FULL_SUB_MASK;
+}
+
+// Silence around frames, in seconds: `lead` before a transmission, `trail`
+// after a single frame, `gap` before each later chunk frame; null = default.
+OFDM.guard = { lead: null, trail: null, gap: null };
+const CHUNK_FRAME_TAIL = 0.02;
 
+function setFrameGuard(guard) {
+    for (const k of ['lead', 'trail', 'gap']) {
+        const v = guard[k];
+        if (v === null || (Number.isFinite(v) && v >= 0)) OFDM.guard[k] = v;
+    }
+}
+
+function frameGuardSeconds(which, cfg = OFDM) {
+    const v = OFDM.guard[which];
+    if (v !== null) return v;
+    const isAcoustic = cfg.CP_LEN >= 128;
+    if (which === 'lead') return isAcoustic ? 0.5 : 0.3;
+    if (which === 'trail') return isAcoustic ? 0.5 : 0.2;
+    return 0.05;
+}
+
+function frameGuardSamples(which) {
+    return Math.round(OFDM.SAMPLE_RATE * frameGuardSeconds(which));
+}
+
+// Transmit level: every frame is scaled, as a whole, so its peak sits at
+// outputAmplitude (full scale = 1). One gain per frame keeps the preamble,
+// CE and data symbols at the level the channel estimate assumes. Values
+// above 1 would clip, so they are clamped.
+OFDM.outputAmplitude = 0.8;
+
+function setOutputAmplitude(amplitude) {
+    OFDM.outputAmplitude = Number.isFinite(amplitude) && amplitude > 0 ? Math.min(amplitude, 1) : 0.8;
+}
+
+function scaleToOutputAmplitude(signal) {
+    let mx = 0;
+    for (let i = 0; i < signal.length; i++) mx = Math.max(mx, Math.abs(signal[i]));
+    if (mx > 0) { const s = OFDM.outputAmplitude / mx; for (let i = 0; i < signal.length; i++) signal[i] *= s; }
+    return signal;
+}
+
+// Optional chirp across the data band ahead of a transmission's first frame,
+// for checking the band at the receiver. Fast enough not to look like a preamble.
+const CALIBRATION_CHIRP_SECONDS = 0.5;
+const CALIBRATION_CHIRP_RATE = 60000; // Hz/s
+OFDM.calibrationChirp = false;
+
+function setCalibrationChirp(on) {
+    OFDM.calibrationChirp = !!on;
+}
+
+function generateCalibrationChirp() {
+    const binHz = OFDM.SAMPLE_RATE / OFDM.FFT_SIZE;
+    const f0 = OFDM.SUB_START * binHz, f1 = OFDM.SUB_END * binHz;
+    const half = (f1 - f0) / CALIBRATION_CHIRP_RATE; // one sweep, up or down
+    const period = 2 * half;
+    const periods = Math.max(1, Math.round(CALIBRATION_CHIRP_SECONDS / period));
+    const up = u => 2 * Math.PI * (f0 * u + CALIBRATION_CHIRP_RATE * u * u / 2);
+    const down = u => up(half) + 2 * Math.PI * (f1 * u - CALIBRATION_CHIRP_RATE * u * u / 2);
+    const phaseAt = t => {
+        const n = Math.floor(t / period), u = t - n * period;
+        return n * down(half) + (u < half ? up(u) : down(u - half));
+    };
+    return generateTone(periods * period, OFDM.SAMPLE_RATE, phaseAt);
+}
+
+function withCalibrationChirp(signal) {
+    if (!OFDM.calibrationChirp) return signal;
+    const chirp = generateCalibrationChirp();
+    const out = new Float32Array(chirp.length + signal.length);
+    out.set(chirp);
+    out.set(signal, chirp.length);
+    return out;
+}
+
+// A config may give NUM_PILOTS instead of a PILOTS list
 function setOFDMConfig(name) {
     const cfg = OFDM_CONFIGS[name] || OFDM_CONFIGS.standard;
     Object.keys(cfg).forEach(k => { OFDM[k] = cfg[k]; });
+    if (!cfg.PILOTS) OFDM.PILOTS = generatePilots(cfg.SUB_START, cfg.SUB_END, cfg.NUM_PILOTS);
+    OFDM.PILOT_BOOST_DB = cfg.PILOT_BOOST_DB || 0;
+    OFDM.PILOT_SHIFTS = cfg.PILOT_SHIFTS || 1;
+    OFDM.PILOT_AMP = pilotAmplitudes(OFDM);
+}
+
+// Pilot boosting: amplitudes of pilots and data points for cfg, with pilots
+// PILOT_BOOST_DB louder and data giving up that power (symbol energy unchanged).
+const MAX_PILOT_BOOST_DB = 6;
+
+function pilotAmplitudes(cfg = OFDM) {
+    const pilot = Math.pow(10, (cfg.PILOT_BOOST_DB || 0) / 20);
+    const pilots = cfg.PILOTS || generatePilots(cfg.SUB_START, cfg.SUB_END, cfg.NUM_PILOTS);
+    const numPilots = pilots.filter(k => k >= cfg.SUB_START && k <= cfg.SUB_END).length;
+    const numData = cfg.SUB_END - cfg.SUB_START + 1 - numPilots;
+    const dataPower = (numData + numPilots - numPilots * pilot * pilot) / numData;
+    return { pilot, data: Math.sqrt(Math.max(0, dataPower)) };
+}
+
+// Adds config `name`: `baseName`'s timing moved to the band startHz–endHz
+// (rounded inwards to whole subcarriers). opts: numPilots, pilotBoostDb, pilotShifts.
+const MIN_BAND_SUBS = 8;
+
+// Why cfg's band can't be carried at sampleRate (default: the config's own),
+// or null. A high band fits at 44.1 kHz but not on a device running slower,
+// e.g. a 16 kHz Bluetooth headset profile.
+function bandConfigError(cfg, sampleRate = cfg.SAMPLE_RATE) {
+    const binHz = cfg.SAMPLE_RATE / cfg.FFT_SIZE;
+    if (!(cfg.SUB_START >= 1)) return 'Band must start above DC';
+    if (!(cfg.SUB_END * binHz < Math.min(cfg.SAMPLE_RATE, sampleRate) / 2)) return 'Band must end below Nyquist';
+    if (!(cfg.SUB_END - cfg.SUB_START + 1 >= MIN_BAND_SUBS)) return 'Band too narrow';
+    return null;
+}
+
+function defineBandConfig(name, baseName, startHz, endHz, opts = {}) {
+    const base = OFDM_CONFIGS[baseName];
+    if (!base) return { error: `Unknown config: ${baseName}` };
+    const binHz = base.SAMPLE_RATE / base.FFT_SIZE;
+    const cfg = { ...base, SUB_START: Math.ceil(startHz / binHz), SUB_END: Math.floor(endHz / binHz) };
+    const error = bandConfigError(cfg);
+    if (error) return { error };
+    const numPilots = opts.numPilots || Math.round((cfg.SUB_END - cfg.SUB_START + 1) / 14);
+    cfg.PILOTS = generatePilots(cfg.SUB_START, cfg.SUB_END, numPilots);
+    delete cfg.NUM_PILOTS;
+    const boost = opts.pilotBoostDb || 0;
+    if (!(boost >= 0 && boost <= MAX_PILOT_BOOST_DB)) return { error: `Pilot boost must be 0–${MAX_PILOT_BOOST_DB} dB` };
+    cfg.PILOT_BOOST_DB = boost;
+    // Half the data amplitude is where boosting costs more than it gains
+    if (pilotAmplitudes(cfg).data < 0.5) return { error: 'Pilot boost leaves too little power for data' };
+    // More shifts than subcarriers between pilots would revisit positions
+    const spacing = Math.floor((cfg.SUB_END - cfg.SUB_START + 1) / cfg.PILOTS.length);
+    cfg.PILOT_SHIFTS = Math.max(1, Math.min(opts.pilotShifts | 0, spacing));
+    OFDM_CONFIGS[name] = cfg;
+    return cfg;
+}
+
+// numPilots pilots at the centres of equal slices of [start, end]; at least
+// 2 (the phase slope needs two), at most every other subcarrier.
+function generatePilots(start, end, numPilots = 8) {
+    const n = end - start + 1;
+    const count = Math.max(2, Math.min(numPilots | 0, n >> 1));
+    const pilots = [];
+    for (let i = 0; i < count; i++) pilots.push(start + Math.floor((i + 0.5) * n / count));
+    return pilots;
 }
 
 // --- Constellation ---
+// minSnrDb: per-subcarrier SNR needed for a low symbol error rate
 const Constellations = {
-    BPSK: { bps: 1, points: null },
-    QPSK: { bps: 2, points: null },
-    QAM16: { bps: 4, points: null },
+    BPSK: { bps: 1, points: null, minSnrDb: 7 },
+    QPSK: { bps: 2, points: null, minSnrDb: 10 },
+    QAM16: { bps: 4, points: null, minSnrDb: 17 },
 };
 
 function initConstellation(name) {
@@ -111,8 +391,9 @@ function initConstellation(name) {
         c.points = [[1, 0], [-1, 0]];
     } else if (name === 'QPSK') {
         const s = 1 / Math.SQRT2;
+        // Gray labelled: each bit picks one axis, so neighbours differ by one bit
         c.points = [
-            [s, s], [-s, s], [-s, -s], [s, -s]
+            [s, s], [-s, s], [s, -s], [-s, -s]
         ];
     } else if (name === 'QAM16') {
         const raw = [];
@@ -121,35 +402,73 @@ function initConstellation(name) {
             const gr = row ^ (row >> 1), gc = col ^ (col >> 1);
             raw.push([2 * gc - 3, 2 * gr - 3]);
         }
-        let avg = 0;
-        for (const p of raw) avg += p[0] * p[0] + p[1] * p[1];
-        avg /= raw.length;
-        const s = 1 / Math.sqrt(avg);
-        c.points = raw.map(p => [p[0] * s, p[1] * s]);
+        c.points = normalizePoints(raw);
     }
     return c;
 }
 
-function constellationMap(c, bits) {
+// Scales points to unit average power
+function normalizePoints(raw) {
+    let avg = 0;
+    for (const p of raw) avg += p[0] * p[0] + p[1] * p[1];
+    avg /= raw.length;
+    const s = 1 / Math.sqrt(avg);
+    return raw.map(p => [p[0] * s, p[1] * s]);
+}
+
+// Adds a custom constellation usable anywhere a modulation name is taken.
+// points[i] = [re, im] is the symbol for bit pattern i (MSB first), so the
+// bit labelling (e.g. Gray) is the caller's choice. The count must be a
+// power of two; points are normalized to unit average power.
+function registerConstellation(name, points, minSnrDb = 20) {
+    const n = Array.isArray(points) ? points.length : 0;
+    if (n < 2 || (n & (n - 1)) !== 0) return { error: 'Constellation size must be a power of two' };
+    if (Constellations[name] && !Constellations[name].custom) return { error: `Built-in constellation: ${name}` };
+    const c = { bps: Math.log2(n), points: normalizePoints(points), minSnrDb, custom: true };
+    Constellations[name] = c;
+    return c;
+}
+
+// Maps c.bps bits starting at bits[off] (missing bits count as 0)
+function constellationMap(c, bits, off = 0) {
     let idx = 0;
-    for (const b of bits) idx = (idx << 1) | (b & 1);
+    for (let i = 0; i < c.bps; i++) idx = (idx << 1) | (bits[off + i] & 1);
     const p = c.points[idx % c.points.length];
     return p;
 }
 
-function constellationDemap(c, re, im) {
+// Index of the nearest constellation point
+function constellationDemapIndex(c, re, im) {
     let minD = Infinity, minIdx = 0;
     for (let i = 0; i < c.points.length; i++) {
         const dr = re - c.points[i][0], di = im - c.points[i][1];
         const d = dr * dr + di * di;
         if (d < minD) { minD = d; minIdx = i; }
     }
+    return minIdx;
+}
+
+function constellationDemap(c, re, im) {
+    const minIdx = constellationDemapIndex(c, re, im);
     const bits = [];
     for (let i = c.bps - 1; i >= 0; i--) bits.push((minIdx >> i) & 1);
     return bits;
 }
 
 // --- Preamble (Schmidl-Cox) ---
+// PN seeds of preamble 1, preamble 2 and CE/re-sync are base, base+1, base+2
+// with base = 42 + 3·linkSeed; link 0 keeps the original 42/43/44.
+const PREAMBLE_BASE_SEED = 42;
+OFDM.linkSeed = 0;
+
+function setLinkSeed(seed) {
+    OFDM.linkSeed = Number.isInteger(seed) && seed >= 0 ? seed : 0;
+}
+
+function preambleSeed(index) {
+    return PREAMBLE_BASE_SEED + 3 * OFDM.linkSeed + index;
+}
+
 function seededRandom(seed) {
     let s = seed;
     return () => { s = (s * 1103515245 + 12345) & 0x7fffffff; return s / 0x7fffffff; };
@@ -158,36 +477,97 @@ function seededRandom(seed) {
 function generatePreambleSymbol1() {
     const re = new Float64Array(OFDM.FFT_SIZE);
     const im = new Float64Array(OFDM.FFT_SIZE);
-    const rng = seededRandom(42);
+    const rng = seededRandom(preambleSeed(0));
     for (let k = OFDM.SUB_START; k <= OFDM.SUB_END; k += 2) {
         re[k] = rng() > 0.5 ? 1 : -1;
     }
     const n = OFDM.FFT_SIZE;
     for (let k = 1; k < n / 2; k++) { re[n - k] = re[k]; im[n - k] = -im[k]; }
     re[0] = 0; re[n / 2] = 0; im[n / 2] = 0;
-    const [td] = ifft(re, im);
+    const td = irfft(re, im);
     return addCP(td);
 }
 
 function generatePreambleSymbol2() {
     const re = new Float64Array(OFDM.FFT_SIZE);
     const im = new Float64Array(OFDM.FFT_SIZE);
-    const rng = seededRandom(43);
+    const rng = seededRandom(preambleSeed(1));
     for (let k = OFDM.SUB_START; k <= OFDM.SUB_END; k++) {
         re[k] = rng() > 0.5 ? 1 : -1;
     }
     const n = OFDM.FFT_SIZE;
     for (let k = 1; k < n / 2; k++) { re[n - k] = re[k]; im[n - k] = -im[k]; }
     re[0] = 0; re[n / 2] = 0; im[n / 2] = 0;
-    const [td] = ifft(re, im);
+    const td = irfft(re, im);
     return addCP(td);
 }
 
+// [preamble1 × preambleRepeats][preamble2]
+function generatePreambleTrain() {
+    const pre1 = generatePreambleSymbol1();
+    const pre2 = generatePreambleSymbol2();
+    const n = OFDM.preambleRepeats;
+    const out = new Float32Array(n * pre1.length + pre2.length);
+    for (let r = 0; r < n; r++) out.set(pre1, r * pre1.length);
+    out.set(pre2, n * pre1.length);
+    return out;
+}
+
+// --- Repeated preamble ---
+// N copies of preamble 1; the detector sums the half-symbol correlations of
+// N windows a symbol apart, M = (Σ P_r)² / (N · Σ Ra_r·Rb_r), which peaks
+// at the last copy. Sender and receiver must agree on N, like the link seed.
+const MAX_PREAMBLE_REPEATS = 4;
+const PREAMBLE_METRIC_MIN = 0.5; // over N: the noise on M falls with N
+OFDM.preambleRepeats = 1;
+
+function setPreambleRepeats(n) {
+    OFDM.preambleRepeats = Number.isInteger(n) && n >= 1 ? Math.min(n, MAX_PREAMBLE_REPEATS) : 1;
+}
+
+function preambleMetricMin() {
+    return PREAMBLE_METRIC_MIN / OFDM.preambleRepeats;
+}
+
+// Samples the extra copies add ahead of a frame
+function extraPreambleSamples() {
+    return (OFDM.preambleRepeats - 1) * OFDM.SYMBOL_LEN;
+}
+
+// Window sums of the last (N-1) symbols of positions; push() returns the
+// combined metric at each position in turn (0 below minEnergy).
+class PreambleCombiner {
+    constructor(repeats = OFDM.preambleRepeats) {
+        this.n = repeats;
+        this.size = (repeats - 1) * OFDM.SYMBOL_LEN + 1;
+        this.p = new Float64Array(this.size);
+        this.ra = new Float64Array(this.size);
+        this.rb = new Float64Array(this.size);
+    }
+
+    reset() {
+        this.p.fill(0); this.ra.fill(0); this.rb.fill(0);
+    }
+
+    push(pos, p, ra, rb, minEnergy) {
+        if (this.n === 1) return ra > minEnergy && rb > minEnergy ? (p * p) / (ra * rb) : 0;
+        const i = pos % this.size;
+        this.p[i] = p; this.ra[i] = ra; this.rb[i] = rb;
+        let sp = 0, sra = 0, srb = 0, sprod = 0;
+        for (let r = 0; r < this.n; r++) {
+            const j = (i - r * OFDM.SYMBOL_LEN + this.size) % this.size;
+            sp += this.p[j]; sra += this.ra[j]; srb += this.rb[j];
+            sprod += this.ra[j] * this.rb[j];
+        }
+        return sra > minEnergy && srb > minEnergy ? (sp * sp) / (this.n * sprod) : 0;
+    }
+}
+
 function generateChannelEstSymbol() {
     const re = new Float64Array(OFDM.FFT_SIZE);
     const im = new Float64Array(OFDM.FFT_SIZE);
     const knownRe = new Float64Array(OFDM.FFT_SIZE);
-    const rng = seededRandom(44);
+    const rng = seededRandom(preambleSeed(2));
     for (let k = OFDM.SUB_START; k <= OFDM.SUB_END; k++) {
         const v = rng() > 0.5 ? 1 : -1;
         re[k] = v; knownRe[k] = v;
@@ -195,7 +575,7 @@ function generateChannelEstSymbol() {
     const n = OFDM.FFT_SIZE;
     for (let k = 1; k < n / 2; k++) { re[n - k] = re[k]; im[n - k] = -im[k]; }
     re[0] = 0; re[n / 2] = 0; im[n / 2] = 0;
-    const [td] = ifft(re, im);
+    const td = irfft(re, im);
     return { samples: addCP(td), knownRe, knownIm: new Float64Array(OFDM.FFT_SIZE) };
 }
 
@@ -207,11 +587,12 @@ function addCP(td) {
     return out;
 }
 
-// --- Signal Preprocessing (DC removal + normalize only) ---
-// Note: bandpass filtering omitted because it distorts cross-correlation.
-// The OFDM channel equalizer handles frequency response naturally.
+// --- Signal Preprocessing (band-pass, DC removal, normalize) ---
+// The band-pass is zero-phase, so cross-correlation is unaffected; what is
+// left of the frequency response the OFDM channel equalizer handles.
 function preprocessSignal(signal) {
-    // 1. DC removal
+    // 1. Out-of-band rejection, then DC removal
+    signal = bandpass(signal);
     let mean = 0;
     for (let i = 0; i < signal.length; i++) mean += signal[i];
     mean /= signal.length;
@@ -231,6 +612,26 @@ function preprocessSignal(signal) {
     return out;
 }
 
+// --- Automatic Gain Control ---
+// Gain towards `target` RMS that follows the level over time (attack and
+// release in seconds, capped at maxGain). Only preamble searches use it.
+function applyAGC(signal, { attack = 0.01, release = 0.5, target = 0.25, maxGain = 1000 } = {}) {
+    const a = 1 - Math.exp(-1 / (attack * OFDM.SAMPLE_RATE));
+    const r = 1 - Math.exp(-1 / (release * OFDM.SAMPLE_RATE));
+    const out = new Float32Array(signal.length);
+    // Start from the opening block's power so the first samples are not over-gained
+    let env = 0;
+    const head = Math.min(signal.length, Math.round(attack * OFDM.SAMPLE_RATE) || 1);
+    for (let i = 0; i < head; i++) env += signal[i] * signal[i];
+    env /= head || 1;
+    for (let i = 0; i < signal.length; i++) {
+        const p = signal[i] * signal[i];
+        env += (p > env ? a : r) * (p - env);
+        out[i] = signal[i] * Math.min(maxGain, target / Math.sqrt(env + 1e-20));
+    }
+    return out;
+}
+
 // --- Preamble Detection: Cross-Correlation (robust for acoustic) ---
 function detectPreambleCrossCorr(signal) {
     const pre1 = generatePreambleSymbol1();
@@ -284,9 +685,18 @@ function detectPreambleCrossCorr(signal) {
 
 // --- Preamble Detection: Auto-Correlation (Sliding Window, O(n)) ---
 function detectPreamble(signal) {
+    const candidates = detectPreambleCandidates(signal, 1);
+    return candidates.length > 0 ? candidates[0] : -1;
+}
+
+// Up to k preamble start indices over the threshold, best first, each a whole
+// preamble (all its copies) from every better one. Each one tried costs a decode.
+const PREAMBLE_CANDIDATES = 4;
+
+function detectPreambleCandidates(signal, k = PREAMBLE_CANDIDATES) {
     const half = OFDM.FFT_SIZE / 2; // 256
     const len = signal.length;
-    if (len < 2 * half) return -1;
+    if (len < 2 * half) return [];
 
     // Compute initial P(0), Ra(0), Rb(0)
     let p = 0, ra = 0, rb = 0;
@@ -297,15 +707,28 @@ function detectPreamble(signal) {
         rb += b * b;
     }
 
-    let best = 0, bestIdx = -1;
     const end = len - 2 * half;
     const minEnergy = 0.01;
+    const combiner = new PreambleCombiner();
 
+    // A repeated preamble's partial alignments lie within its N symbols.
+    // Keeps the best k so far, best first; a position within span of a
+    // better one is dropped, and one that beats its neighbours evicts them.
+    const candidates = [], threshold = preambleMetricMin();
+    const span = OFDM.preambleRepeats * OFDM.SYMBOL_LEN;
     for (let d = 0; d <= end; d++) {
-        // Normalized metric: p² / (ra * rb) ∈ [0, 1] (Pearson r²)
-        if (ra > minEnergy && rb > minEnergy) {
-            const metric = (p * p) / (ra * rb);
-            if (metric > best) { best = metric; bestIdx = d; }
+        // Normalized metric: p² / (ra * rb) ∈ [0, 1] (Pearson r²), over the repeats
+        const metric = combiner.push(d, p, ra, rb, minEnergy);
+        if (metric > threshold && !candidates.some(c => d - c.index <= span && c.metric >= metric)) {
+            for (let i = candidates.length - 1; i >= 0; i--) {
+                if (d - candidates[i].index <= span) candidates.splice(i, 1);
+            }
+            let at = 0;
+            while (at < candidates.length && candidates[at].metric >= metric) at++;
+            if (at < k) {
+                candidates.splice(at, 0, { index: d, metric });
+                if (candidates.length > k) candidates.pop();
+            }
         }
         if (d < end) {
             const aOut = signal[d], mid = signal[d + half], bIn = signal[d + 2 * half];
@@ -314,117 +737,587 @@ function detectPreamble(signal) {
             rb += bIn * bIn  - mid  * mid;
         }
     }
+    return candidates.map(c => c.index);
+}
+
+// --- Preamble Refinement: Matched Filter Around a Coarse Hit ---
+// Correlates against preamble 1 within radius of the coarse index → { index,
+// metric } (normalized; -Infinity if nothing was tested), index the last copy.
+const PREAMBLE_STEP_RADIUS = 8;
+
+function refinePreambleCrossCorr(signal, coarseIdx, radius = OFDM.CP_LEN * 3, pre1 = generatePreambleSymbol1()) {
+    let tEnergy = 0;
+    for (let i = 0; i < pre1.length; i++) tEnergy += pre1[i] * pre1[i];
 
-    return best > 0.5 ? bestIdx : -1;
+    let best = crossCorrPeak(signal, coarseIdx - radius, coarseIdx + radius, pre1, tEnergy, coarseIdx);
+    for (let r = 1; r < OFDM.preambleRepeats; r++) {
+        const at = best.index + OFDM.SYMBOL_LEN;
+        const next = crossCorrPeak(signal, at - PREAMBLE_STEP_RADIUS, at + PREAMBLE_STEP_RADIUS, pre1, tEnergy, at);
+        if (next.metric < Math.max(0.1, best.metric / 2)) break;
+        best = next;
+    }
+    return best;
+}
+
+function crossCorrPeak(signal, from, to, pre1, tEnergy, index) {
+    const start = Math.max(0, from);
+    const end = Math.min(signal.length - pre1.length, to);
+
+    let bestMetric = -Infinity;
+    for (let d = start; d <= end; d++) {
+        let corr = 0, sEnergy = 0;
+        for (let i = 0; i < pre1.length; i++) {
+            corr += signal[d + i] * pre1[i];
+            sEnergy += signal[d + i] * signal[d + i];
+        }
+        const denom = Math.sqrt(sEnergy * tEnergy);
+        if (denom > 0.001) {
+            const metric = corr / denom;
+            if (metric > bestMetric) { bestMetric = metric; index = d; }
+        }
+    }
+    return { index, metric: bestMetric };
 }
 
 // --- Modulation ---
-function modulateOFDM(bits, modName) {
+// Pilots carry a real value (OFDM.PILOT_AMP.pilot, 1 unboosted) on every
+// data symbol; data points are scaled by OFDM.PILOT_AMP.data.
+
+// Frame header: BPSK symbol(s) opening the data section, cycled across every
+// data subcarrier: [version:3][beacon:1][totalBits:20][subMask:16][CRC-8:8].
+// A newer version is rejected (DECODE_FAIL.VERSION) rather than misread.
+const FRAME_HEADER_BITS = 48;
+const PROTOCOL_VERSION = 0;
+const HEADER_BEACON_FLAG = 0x10;
+const MAX_FRAME_BITS = (1 << 20) - 1; // ~128KB in one frame without repetition
+// At least this many copies of each header bit, spread FRAME_HEADER_BITS
+// subcarriers apart, so a notch can't take out every copy of a bit.
+const FRAME_HEADER_COPIES = 2;
+
+// Builds one OFDM symbol (with CP) from the points for the data subcarriers
+// in subs (default: all of them) and the pilots; the rest of the band stays
+// empty.
+function buildOFDMSymbol(points, subs = OFDM.dataSubcarriers(FULL_SUB_MASK), pilots = OFDM.PILOTS) {
+    const specRe = new Float64Array(OFDM.FFT_SIZE);
+    const specIm = new Float64Array(OFDM.FFT_SIZE);
+    const amp = OFDM.PILOT_AMP;
+
+    for (const k of pilots) {
+        if (k >= OFDM.SUB_START && k <= OFDM.SUB_END) specRe[k] = amp.pilot;
+    }
+    for (let i = 0; i < subs.length; i++) {
+        specRe[subs[i]] = points[i][0] * amp.data; specIm[subs[i]] = points[i][1] * amp.data;
+    }
+
+    // Hermitian symmetry
+    const n = OFDM.FFT_SIZE;
+    for (let k = 1; k < n / 2; k++) { specRe[n - k] = specRe[k]; specIm[n - k] = -specIm[k]; }
+    specRe[0] = 0; specIm[0] = 0; specIm[n / 2] = 0;
+
+    const td = irfft(specRe, specIm);
+    return addCP(td);
+}
+
+function frameHeaderBits(totalBits, mask, beacon) {
+    const hdr = new Uint8Array([(PROTOCOL_VERSION << 5) | (beacon ? HEADER_BEACON_FLAG : 0) | ((totalBits >> 16) & 0x0F),
+        (totalBits >> 8) & 0xFF, totalBits & 0xFF,
+        (mask >> 8) & 0xFF, mask & 0xFF]);
+    return bytesToBits([...hdr, crc8(hdr)]);
+}
+
+function modulateFrameHeader(totalBits, mask, beacon) {
+    const bpsk = initConstellation('BPSK');
+    const hdrBits = frameHeaderBits(totalBits, mask, beacon);
+    const numDataSubs = OFDM.numDataSubs();
+    const symbols = [];
+    for (let h = 0; h < OFDM.numHeaderSymbols(); h++) {
+        const points = [];
+        for (let di = 0; di < numDataSubs; di++) {
+            points.push(constellationMap(bpsk, [hdrBits[(h * numDataSubs + di) % FRAME_HEADER_BITS]]));
+        }
+        symbols.push(buildOFDMSymbol(points));
+    }
+    return symbols;
+}
+
+function modulateOFDM(bits, modName, beacon = false) {
     const c = initConstellation(modName);
     const bps = c.bps;
-    const numDataSubs = OFDM.numDataSubs();
-    const bitsPerSymbol = numDataSubs * bps;
+    const bitsPerSymbol = OFDM.dataSubcarriers().length * bps;
+    const allSamples = modulateFrameHeader(bits.length, OFDM.subMask, beacon);
 
     // Pad bits
     while (bits.length % bitsPerSymbol !== 0) bits.push(0);
 
     const numSymbols = bits.length / bitsPerSymbol;
-    const allSamples = [];
+    const syncSymbol = OFDM.SYNC_INTERVAL > 0 ? generateChannelEstSymbol().samples : null;
 
     for (let s = 0; s < numSymbols; s++) {
-        const symBits = bits.slice(s * bitsPerSymbol, (s + 1) * bitsPerSymbol);
-        const specRe = new Float64Array(OFDM.FFT_SIZE);
-        const specIm = new Float64Array(OFDM.FFT_SIZE);
+        if (syncSymbol && s > 0 && s % OFDM.SYNC_INTERVAL === 0) allSamples.push(syncSymbol);
+        const pilots = OFDM.pilotsForSymbol(s);
+        const subs = OFDM.dataSubcarriers(OFDM.subMask, pilots);
+        const points = [];
+        for (let di = 0; di < subs.length; di++) {
+            const off = s * bitsPerSymbol + di * bps;
+            points.push(constellationMap(c, bits, off));
+        }
+        allSamples.push(buildOFDMSymbol(points, subs, pilots));
+    }
 
-        let di = 0;
+    return { samples: allSamples, numSymbols, bitsPerSymbol };
+}
+
+// --- Demodulation ---
+
+// Zero-forcing equalization (noise power taken as 0) instead of MMSE, for
+// comparing the two on a faded channel; receivers always use MMSE.
+OFDM.equalizer = 'mmse';
+
+function setEqualizer(name) {
+    OFDM.equalizer = name === 'zf' ? 'zf' : 'mmse';
+}
+
+// Working buffers for equalizeSymbol. Passing the same scratch for every
+// data symbol avoids allocating per symbol; the returned re/im/gain alias
+// it, so they are only valid until the next call with that scratch.
+function createSymbolScratch() {
+    const n = OFDM.FFT_SIZE;
+    return {
+        td: new Float64Array(n),
+        spec: [new Float64Array(n), new Float64Array(n)],
+        re: new Float64Array(n), im: new Float64Array(n), gain: new Float64Array(n),
+    };
+}
+
+// FFT, MMSE equalization and pilot phase tracking for the symbol whose FFT
+// window starts at win. re/im hold the corrected, unbiased points; delay is
+// the residual timing offset in samples seen on the pilots.
+function equalizeSymbol(signal, win, channelRe, channelIm, scratch = createSymbolScratch(), pilots = OFDM.PILOTS) {
+    const re = scratch.td;
+    for (let i = 0; i < OFDM.FFT_SIZE; i++) {
+        re[i] = signal[win + i] || 0;
+    }
+
+    // FFT
+    const [specRe, specIm] = rfft(re, scratch.spec);
+
+    // Equalize (MMSE, noise power re-estimated from pilots every symbol)
+    const amp = OFDM.PILOT_AMP;
+    const noisePower = estimateNoisePower(specRe, specIm, channelRe, channelIm, amp.pilot, pilots);
+    const eqRe = scratch.re, eqIm = scratch.im, gain = scratch.gain;
+    equalizeMMSE(specRe, specIm, channelRe, channelIm, OFDM.equalizer === 'zf' ? 0 : noisePower, eqRe, eqIm, gain);
+
+    // Phase tracking from pilots: a linear ramp across subcarriers (sample
+    // clock offset / residual timing) plus a common phase. Summing the MMSE
+    // outputs lets faded pilots contribute little.
+    const slope = estimateSampleClockOffset(eqRe, eqIm, pilots);
+    let pRe = 0, pIm = 0;
+    for (const p of pilots) {
+        if (p >= OFDM.SUB_START && p <= OFDM.SUB_END) {
+            const c = Math.cos(slope * p), sn = Math.sin(slope * p);
+            pRe += eqRe[p] * c + eqIm[p] * sn;
+            pIm += eqIm[p] * c - eqRe[p] * sn;
+        }
+    }
+    const phase = Math.atan2(pIm, pRe);
+
+    // De-rotate and undo the MMSE bias (and the data scaling of a pilot
+    // boost) so QAM decision regions stay put
+    for (let k = OFDM.SUB_START; k <= OFDM.SUB_END; k++) {
+        const g = (gain[k] > 1e-6 ? gain[k] : 1) * (pilots.includes(k) ? 1 : amp.data);
+        const rot = phase + slope * k;
+        const cosP = Math.cos(rot), sinP = Math.sin(rot);
+        const cr = (eqRe[k] * cosP + eqIm[k] * sinP) / g;
+        const ci = (eqIm[k] * cosP - eqRe[k] * sinP) / g;
+        eqRe[k] = cr; eqIm[k] = ci;
+    }
+
+    return { re: eqRe, im: eqIm, gain, noisePower, delay: -slope * OFDM.FFT_SIZE / (2 * Math.PI) };
+}
+
+// Pilot-aided gain tracking: the pilots' common gain, smoothed by a one-pole
+// average (GAIN_TRACK_ALPHA per symbol) restarted at every channel estimate.
+OFDM.gainTracking = true;
+const GAIN_TRACK_ALPHA = 0.2;
+
+// Common gain of the equalized, de-rotated pilots against their amplitude,
+// weighted by MMSE gain like the phase estimate. 1 when the pilots carry
+// nothing usable (no signal) or the estimate is implausible.
+function estimatePilotGain(eqRe, eqIm, gain, pilots = OFDM.PILOTS) {
+    const pilot = OFDM.PILOT_AMP.pilot;
+    let num = 0, den = 0;
+    for (const p of pilots) {
+        if (p < OFDM.SUB_START || p > OFDM.SUB_END) continue;
+        num += gain[p] * eqRe[p] * pilot;
+        den += gain[p] * pilot * pilot;
+    }
+    const g = den > 1e-9 ? num / den : 1;
+    return g > 0.1 && g < 10 ? g : 1;
+}
+
+// Decodes the frame header from its equalized symbols; gain-weighted soft
+// combining across the repeated copies of each bit.
+function decodeFrameHeader(eqSymbols) {
+    const acc = new Float64Array(FRAME_HEADER_BITS);
+    let g = 0;
+    for (const eq of eqSymbols) {
         for (let k = OFDM.SUB_START; k <= OFDM.SUB_END; k++) {
-            if (OFDM.isPilot(k)) {
-                specRe[k] = 1; specIm[k] = 0;
-            } else {
-                const b = symBits.slice(di * bps, (di + 1) * bps);
-                const p = constellationMap(c, b);
-                specRe[k] = p[0]; specIm[k] = p[1];
-                di++;
-            }
+            if (OFDM.isPilot(k)) continue;
+            acc[g++ % FRAME_HEADER_BITS] += eq.re[k] * eq.gain[k];
         }
+    }
+    const bits = Array.from(acc, v => (v < 0 ? 1 : 0));
+    const hdr = bitsToBytes(bits);
+    if (crc8(hdr.subarray(0, 5)) !== hdr[5]) return { error: 'Frame header CRC mismatch', reason: DECODE_FAIL.HEADER };
+    const version = hdr[0] >> 5;
+    if (version > PROTOCOL_VERSION) {
+        return { error: `Incompatible protocol version ${version} (this build reads ${PROTOCOL_VERSION})`, reason: DECODE_FAIL.VERSION, version };
+    }
+    const mask = (hdr[3] << 8) | hdr[4];
+    if (mask === 0) return { error: 'Frame header has an empty subcarrier mask', reason: DECODE_FAIL.HEADER };
+    return { totalBits: ((hdr[0] & 0x0F) << 16) | (hdr[1] << 8) | hdr[2], mask, version, beacon: !!(hdr[0] & HEADER_BEACON_FLAG) };
+}
+
+// Reads just the frame header. frameSamples start at the CE symbol.
+function readFrameHeader(frameSamples) {
+    const need = (1 + OFDM.numHeaderSymbols()) * OFDM.SYMBOL_LEN;
+    if (frameSamples.length < need) return { error: 'Frame too short for header', reason: DECODE_FAIL.TRUNCATED };
+    const ce = generateChannelEstSymbol();
+    const [chRe, chIm] = estimateChannel(frameSamples.subarray(0, OFDM.SYMBOL_LEN), ce.knownRe, ce.knownIm);
+    const eqSymbols = [];
+    for (let h = 0; h < OFDM.numHeaderSymbols(); h++) {
+        const win = (1 + h) * OFDM.SYMBOL_LEN + OFDM.fftWindowStart();
+        eqSymbols.push(equalizeSymbol(frameSamples, win, chRe, chIm));
+    }
+    return decodeFrameHeader(eqSymbols);
+}
+
+// Channel tracking on scattered pilots: corr, per pilot-pattern subcarrier,
+// is the channel relative to the last estimate, refined by a one-pole average.
+const PILOT_TRACK_ALPHA = 0.5;
 
-        // Hermitian symmetry
-        const n = OFDM.FFT_SIZE;
-        for (let k = 1; k < n / 2; k++) { specRe[n - k] = specRe[k]; specIm[n - k] = -specIm[k]; }
-        specRe[0] = 0; specIm[0] = 0; specIm[n / 2] = 0;
+function createChannelTracker(channelRe, channelIm) {
+    const grid = new Set();
+    for (let s = 0; s < OFDM.PILOT_SHIFTS; s++) for (const p of OFDM.pilotsForSymbol(s)) grid.add(p);
+    const corrRe = new Float64Array(OFDM.FFT_SIZE).fill(1), corrIm = new Float64Array(OFDM.FFT_SIZE);
+    return { baseRe: channelRe, baseIm: channelIm, grid: [...grid].sort((a, b) => a - b), corrRe, corrIm };
+}
 
-        const [td] = ifft(specRe, specIm);
-        const sym = addCP(td);
-        allSamples.push(sym);
+// eq is the symbol equalized with the tracked channel, so a pilot's ratio to
+// its sent value is what the channel moved since the last update there.
+function updateChannelTracker(tracker, eq, pilots) {
+    const pilot = OFDM.PILOT_AMP.pilot;
+    const { corrRe, corrIm } = tracker;
+    for (const p of pilots) {
+        const rRe = eq.re[p] / pilot, rIm = eq.im[p] / pilot;
+        const tRe = corrRe[p] * rRe - corrIm[p] * rIm, tIm = corrRe[p] * rIm + corrIm[p] * rRe;
+        corrRe[p] += PILOT_TRACK_ALPHA * (tRe - corrRe[p]);
+        corrIm[p] += PILOT_TRACK_ALPHA * (tIm - corrIm[p]);
     }
+}
 
-    return { samples: allSamples, numSymbols, bitsPerSymbol };
+function trackedChannel(tracker) {
+    const { baseRe, baseIm, grid, corrRe, corrIm } = tracker;
+    const chRe = new Float64Array(OFDM.FFT_SIZE), chIm = new Float64Array(OFDM.FFT_SIZE);
+    let g = 0;
+    for (let k = OFDM.SUB_START; k <= OFDM.SUB_END; k++) {
+        while (g < grid.length - 1 && grid[g + 1] <= k) g++;
+        const lo = grid[g], hi = grid[Math.min(g + 1, grid.length - 1)];
+        const t = k <= lo ? 0 : k >= hi ? 1 : (k - lo) / (hi - lo);
+        const cRe = corrRe[lo] + t * (corrRe[hi] - corrRe[lo]), cIm = corrIm[lo] + t * (corrIm[hi] - corrIm[lo]);
+        chRe[k] = baseRe[k] * cRe - baseIm[k] * cIm;
+        chIm[k] = baseRe[k] * cIm + baseIm[k] * cRe;
+    }
+    return [chRe, chIm];
 }
 
-// --- Demodulation ---
+// Demodulates the data section (header symbols first). Returns the coded
+// bits announced by the header and the number of samples the frame used.
 function demodulateOFDM(signal, modName, channelRe, channelIm) {
+    const sync = OFDM.SYNC_INTERVAL > 0 ? generateChannelEstSymbol() : null;
+    let timingAdj = 0; // whole samples the FFT window has followed clock drift
+    let offset = 0;
+    let chPower = channelPower(channelRe, channelIm);
+    let snrSum = 0, snrCount = 0, noiseSum = 0;
+    const drift = new DriftFit();
+
+    // Once the pilot ramp amounts to a whole sample of delay, move the window
+    // for the next symbol so a long frame never drifts out of the CP.
+    const track = (eq) => {
+        drift.add(offset, timingAdj + eq.delay);
+        if (Math.abs(eq.delay) >= 0.75) timingAdj += Math.round(eq.delay);
+        if (eq.noisePower > 0) { snrSum += chPower / eq.noisePower; noiseSum += eq.noisePower; snrCount++; }
+    };
+
+    const hdrSymbols = [];
+    for (let h = 0; h < OFDM.numHeaderSymbols(); h++, offset += OFDM.SYMBOL_LEN) {
+        if (offset + OFDM.SYMBOL_LEN > signal.length) return { error: 'Signal too short for frame header', reason: DECODE_FAIL.TRUNCATED };
+        const eq = equalizeSymbol(signal, offset + OFDM.fftWindowStart() + timingAdj, channelRe, channelIm);
+        hdrSymbols.push(eq);
+        track(eq);
+    }
+    const header = decodeFrameHeader(hdrSymbols);
+    if (header.error) return header;
+
+    modName = frameModulation(header, modName);
     const c = initConstellation(modName);
-    const bps = c.bps;
-    const numSymbols = Math.floor(signal.length / OFDM.SYMBOL_LEN);
+    const numSubs = OFDM.dataSubcarriers(header.mask).length;
+    const bitsPerSymbol = numSubs * c.bps;
+    const numSymbols = Math.ceil(header.totalBits / bitsPerSymbol);
     const allBits = [];
+    let amplitude = 1; // tracked gain since the last channel estimate
+    let evmSum = 0;
+    const scratch = createSymbolScratch(); // reused by every data symbol
+    // Equalized points with their SNR weight |H|²/σ², kept for SoftCombiner
+    const soft = newSoftSymbols(numSymbols * numSubs, header, modName);
+    let tracker = OFDM.PILOT_SHIFTS > 1 ? createChannelTracker(channelRe, channelIm) : null;
+
+    for (let s = 0; s < numSymbols; s++, offset += OFDM.SYMBOL_LEN) {
+        if (sync && s > 0 && s % OFDM.SYNC_INTERVAL === 0) {
+            // Re-sync symbol: re-acquire timing and the channel, then skip it
+            offset = resyncOffset(signal, offset + timingAdj, sync.samples);
+            [channelRe, channelIm] = estimateChannel(signal.subarray(offset, offset + OFDM.SYMBOL_LEN),
+                sync.knownRe, sync.knownIm);
+            chPower = channelPower(channelRe, channelIm);
+            timingAdj = 0;
+            amplitude = 1;
+            if (tracker) tracker = createChannelTracker(channelRe, channelIm);
+            drift.restart();
+            offset += OFDM.SYMBOL_LEN;
+        }
+        if (offset + OFDM.SYMBOL_LEN > signal.length) break;
+
+        const pilots = OFDM.pilotsForSymbol(s);
+        const subs = OFDM.dataSubcarriers(header.mask, pilots);
+        const eq = equalizeSymbol(signal, offset + OFDM.fftWindowStart() + timingAdj, channelRe, channelIm, scratch, pilots);
+        track(eq);
+        // The tracked channel already follows level changes on every pilot
+        if (tracker) {
+            updateChannelTracker(tracker, eq, pilots);
+        } else if (OFDM.gainTracking) {
+            amplitude += GAIN_TRACK_ALPHA * (estimatePilotGain(eq.re, eq.im, eq.gain) - amplitude);
+            for (const k of subs) { eq.re[k] /= amplitude; eq.im[k] /= amplitude; }
+        }
 
-    for (let s = 0; s < numSymbols; s++) {
-        const offset = s * OFDM.SYMBOL_LEN;
-        // Remove CP
-        const re = new Float64Array(OFDM.FFT_SIZE);
-        const im = new Float64Array(OFDM.FFT_SIZE);
-        for (let i = 0; i < OFDM.FFT_SIZE; i++) {
-            re[i] = signal[offset + OFDM.CP_LEN + i] || 0;
+        // Demap
+        for (const k of subs) {
+            const idx = constellationDemapIndex(c, eq.re[k], eq.im[k]);
+            for (let b = c.bps - 1; b >= 0; b--) allBits.push((idx >> b) & 1);
+            const er = eq.re[k] - c.points[idx][0], ei = eq.im[k] - c.points[idx][1];
+            evmSum += er * er + ei * ei;
+            const h2 = channelRe[k] * channelRe[k] + channelIm[k] * channelIm[k];
+            soft.re[soft.count] = eq.re[k]; soft.im[soft.count] = eq.im[k];
+            soft.w[soft.count++] = eq.noisePower > 0 ? h2 / eq.noisePower : 1;
         }
+        if (tracker) [channelRe, channelIm] = trackedChannel(tracker);
+    }
 
-        // FFT
-        const [specRe, specIm] = fft(re, im);
+    if (allBits.length < header.totalBits) return { error: 'Frame truncated', reason: DECODE_FAIL.TRUNCATED, bits: allBits, end: offset };
+    allBits.length = header.totalBits;
+    const snrDb = snrCount > 0 ? 10 * Math.log10(snrSum / snrCount) : null;
+    const suggestedMask = snrCount > 0 ? suggestSubcarrierMask(channelRe, channelIm, noiseSum / snrCount, c.minSnrDb) : FULL_SUB_MASK;
+    const evm = soft.count > 0 ? 100 * Math.sqrt(evmSum / soft.count) : null;
+    if (symbolCapture) symbolCapture(captureSymbols(soft, evm));
+    return { bits: allBits, end: offset + timingAdj, snrDb, evm, mask: header.mask, beacon: header.beacon, suggestedMask, soft, clockOffset: drift.slope() };
+}
 
-        // Equalize
-        const eqRe = new Float64Array(OFDM.FFT_SIZE);
-        const eqIm = new Float64Array(OFDM.FFT_SIZE);
-        for (let k = OFDM.SUB_START; k <= OFDM.SUB_END; k++) {
-            const hr = channelRe[k], hi = channelIm[k];
-            const hMag = hr * hr + hi * hi;
-            if (hMag > 1e-10) {
-                eqRe[k] = (specRe[k] * hr + specIm[k] * hi) / hMag;
-                eqIm[k] = (specIm[k] * hr - specRe[k] * hi) / hMag;
-            } else {
-                eqRe[k] = specRe[k]; eqIm[k] = specIm[k];
-            }
-        }
+// Least-squares slope of the pilot timing against position: the sample clock
+// offset (e.g. 3e-4 = 300 ppm). Every channel estimate resets the timing to
+// its own fractional alignment, so each stretch between estimates gets its
+// own intercept and only the slope is shared.
+class DriftFit {
+    constructor() {
+        this.sxy = 0; this.sxx = 0;
+        this.restart();
+    }
 
-        // Phase correction from pilots
-        let phaseSum = 0, pc = 0;
-        for (const p of OFDM.PILOTS) {
-            if (p >= OFDM.SUB_START && p <= OFDM.SUB_END && Math.abs(eqRe[p]) > 1e-6) {
-                phaseSum += eqIm[p] / eqRe[p];
-                pc++;
-            }
+    restart() {
+        this.fold();
+        this.n = 0; this.sx = 0; this.sy = 0; this.pxy = 0; this.pxx = 0;
+    }
+
+    add(x, y) {
+        this.n++; this.sx += x; this.sy += y; this.pxy += x * y; this.pxx += x * x;
+    }
+
+    // Adds the current stretch, centred on its own means
+    fold() {
+        if (!this.n) return;
+        this.sxy += this.pxy - this.sx * this.sy / this.n;
+        this.sxx += this.pxx - this.sx * this.sx / this.n;
+        this.n = 0;
+    }
+
+    slope() {
+        this.fold();
+        return this.sxx > 0 ? this.sxy / this.sxx : 0;
+    }
+}
+
+// Demodulates after the CE symbol at ceStart → { demod, chRe, chIm }. A
+// pilot drift of SCO_RESAMPLE_MIN or more resamples the frame by that ratio
+// and demodulates it again.
+const SCO_RESAMPLE_MIN = 50e-6;
+
+function demodulateAfterCE(signal, ceStart, modName) {
+    let out = demodulateFromCE(signal, ceStart, modName);
+    const drift = out.demod.clockOffset;
+    if (!out.demod.error && Math.abs(drift) >= SCO_RESAMPLE_MIN) {
+        const again = demodulateFromCE(resample(signal.subarray(ceStart), 1 + drift, 1), 0, modName);
+        if (!again.demod.error) out = again;
+    }
+    return out;
+}
+
+function demodulateFromCE(signal, ceStart, modName) {
+    const ce = generateChannelEstSymbol();
+    const [chRe, chIm] = estimateChannel(signal.subarray(ceStart, ceStart + OFDM.SYMBOL_LEN), ce.knownRe, ce.knownIm);
+    return { demod: demodulateOFDM(signal.subarray(ceStart + OFDM.SYMBOL_LEN), modName, chRe, chIm), chRe, chIm };
+}
+
+// EVM in % of unit constellation power. setSymbolCapture's hook gets each
+// frame's points, thinned to maxPoints: fn({ re, im, evm, modName }).
+let symbolCapture = null, symbolCaptureMax = 4096;
+
+function setSymbolCapture(fn, maxPoints = 4096) {
+    symbolCapture = fn || null;
+    symbolCaptureMax = Math.max(1, maxPoints);
+}
+
+function captureSymbols(soft, evm) {
+    const step = Math.max(1, Math.ceil(soft.count / symbolCaptureMax));
+    const n = Math.ceil(soft.count / step);
+    const re = new Float32Array(n), im = new Float32Array(n);
+    for (let i = 0; i < n; i++) { re[i] = soft.re[i * step]; im[i] = soft.im[i * step]; }
+    return { re, im, evm, modName: soft.modName };
+}
+
+// Subcarrier mask keeping the groups whose effective SNR reaches minSnrDb.
+// The effective SNR is 1 / mean(σ²/|H|²), the noise a group sees after
+// equalization, so one deep null pulls its group down as it should. The
+// receiver can only suggest the mask; there is no back channel to the sender.
+function suggestSubcarrierMask(channelRe, channelIm, noisePower, minSnrDb) {
+    const all = OFDM.dataSubcarriers(FULL_SUB_MASK);
+    const invSnr = new Float64Array(SUB_GROUPS), count = new Float64Array(SUB_GROUPS);
+    const noise = Math.max(noisePower, 1e-12);
+    all.forEach((k, di) => {
+        const g = Math.floor(di * SUB_GROUPS / all.length);
+        const h = channelRe[k] * channelRe[k] + channelIm[k] * channelIm[k];
+        invSnr[g] += noise / Math.max(h, 1e-12);
+        count[g]++;
+    });
+    const minSnr = Math.pow(10, minSnrDb / 10);
+    let mask = 0;
+    for (let g = 0; g < SUB_GROUPS; g++) {
+        if (count[g] > 0 && count[g] / invSnr[g] >= minSnr) mask |= 1 << g;
+    }
+    return mask || FULL_SUB_MASK;
+}
+
+// Mean |H|² over the used band, the received signal power per subcarrier
+function channelPower(channelRe, channelIm) {
+    let sum = 0;
+    for (let k = OFDM.SUB_START; k <= OFDM.SUB_END; k++) {
+        sum += channelRe[k] * channelRe[k] + channelIm[k] * channelIm[k];
+    }
+    return sum / (OFDM.SUB_END - OFDM.SUB_START + 1);
+}
+
+// Best alignment of a known re-sync symbol within half a symbol of its
+// expected offset (normalized cross-correlation).
+function resyncOffset(signal, expected, syncSamples) {
+    const len = syncSamples.length;
+    let tEnergy = 0;
+    for (let i = 0; i < len; i++) tEnergy += syncSamples[i] * syncSamples[i];
+
+    const radius = OFDM.SYMBOL_LEN >> 1;
+    const from = Math.max(0, expected - radius);
+    const to = Math.min(signal.length - len, expected + radius);
+    let best = -Infinity, bestIdx = Math.max(0, Math.min(expected, signal.length - len));
+    for (let d = from; d <= to; d++) {
+        let corr = 0, sEnergy = 0;
+        for (let i = 0; i < len; i++) {
+            corr += signal[d + i] * syncSamples[i];
+            sEnergy += signal[d + i] * signal[d + i];
         }
-        const phase = pc > 0 ? phaseSum / pc : 0;
+        const denom = Math.sqrt(sEnergy * tEnergy);
+        if (denom > 0.001 && corr / denom > best) { best = corr / denom; bestIdx = d; }
+    }
+    return bestIdx;
+}
 
-        // Demap
-        for (let k = OFDM.SUB_START; k <= OFDM.SUB_END; k++) {
-            if (!OFDM.isPilot(k)) {
-                const cr = eqRe[k] + eqIm[k] * phase;
-                const ci = eqIm[k] - eqRe[k] * phase;
-                allBits.push(...constellationDemap(c, cr, ci));
-            }
+// --- Equalization ---
+
+// Noise power per subcarrier from the pilot residuals |Y - H·P·e^jφ|².
+// The common phase φ is removed first so drift isn't counted as noise.
+function estimateNoisePower(specRe, specIm, channelRe, channelIm, pilot = 1, pilots = OFDM.PILOTS) {
+    let cRe = 0, cIm = 0;
+    for (const p of pilots) {
+        if (p < OFDM.SUB_START || p > OFDM.SUB_END) continue;
+        const hr = channelRe[p] * pilot, hi = channelIm[p] * pilot;
+        cRe += specRe[p] * hr + specIm[p] * hi;
+        cIm += specIm[p] * hr - specRe[p] * hi;
+    }
+    const cMag = Math.sqrt(cRe * cRe + cIm * cIm);
+    const rotRe = cMag > 1e-12 ? cRe / cMag : 1, rotIm = cMag > 1e-12 ? cIm / cMag : 0;
+
+    let sum = 0, n = 0;
+    for (const p of pilots) {
+        if (p < OFDM.SUB_START || p > OFDM.SUB_END) continue;
+        const hr = channelRe[p] * pilot, hi = channelIm[p] * pilot;
+        const dr = specRe[p] - (hr * rotRe - hi * rotIm);
+        const di = specIm[p] - (hr * rotIm + hi * rotRe);
+        sum += dr * dr + di * di;
+        n++;
+    }
+    return n > 0 ? sum / n : 0;
+}
+
+// Linear phase slope (rad per subcarrier) across the pilots, from the phase
+// step between neighbouring pilots. A sample clock mismatch shows up as a
+// ramp that grows with every symbol; a per-symbol estimate keeps up with it.
+function estimateSampleClockOffset(eqRe, eqIm, pilots = OFDM.PILOTS) {
+    let sRe = 0, sIm = 0, span = 0, pairs = 0;
+    let prev = -1;
+    for (const p of pilots) {
+        if (p < OFDM.SUB_START || p > OFDM.SUB_END) continue;
+        if (prev >= 0) {
+            // z[p] · conj(z[prev])
+            sRe += eqRe[p] * eqRe[prev] + eqIm[p] * eqIm[prev];
+            sIm += eqIm[p] * eqRe[prev] - eqRe[p] * eqIm[prev];
+            span += p - prev;
+            pairs++;
         }
+        prev = p;
     }
+    if (pairs === 0 || (sRe === 0 && sIm === 0)) return 0;
+    return Math.atan2(sIm, sRe) / (span / pairs);
+}
 
-    return allBits;
+// MMSE equalizer: X = H*·Y / (|H|² + σ²). gain[k] = |H|² / (|H|² + σ²) is the
+// (biased) scale of the output and doubles as a per-subcarrier reliability.
+function equalizeMMSE(specRe, specIm, channelRe, channelIm, noisePower, eqRe, eqIm, gain) {
+    for (let k = OFDM.SUB_START; k <= OFDM.SUB_END; k++) {
+        const hr = channelRe[k], hi = channelIm[k];
+        const hMag = hr * hr + hi * hi;
+        const d = hMag + noisePower;
+        if (d > 1e-10) {
+            eqRe[k] = (specRe[k] * hr + specIm[k] * hi) / d;
+            eqIm[k] = (specIm[k] * hr - specRe[k] * hi) / d;
+            gain[k] = hMag / d;
+        } else {
+            eqRe[k] = 0; eqIm[k] = 0; gain[k] = 0;
+        }
+    }
 }
 
 // --- Channel Estimation ---
 function estimateChannel(receivedSamples, knownRe, knownIm) {
     const re = new Float64Array(OFDM.FFT_SIZE);
-    const im = new Float64Array(OFDM.FFT_SIZE);
     for (let i = 0; i < OFDM.FFT_SIZE; i++) {
-        re[i] = receivedSamples[OFDM.CP_LEN + i] || 0;
+        re[i] = receivedSamples[OFDM.fftWindowStart() + i] || 0;
     }
-    const [specRe, specIm] = fft(re, im);
+    const [specRe, specIm] = rfft(re);
 
     const chRe = new Float64Array(OFDM.FFT_SIZE);
     const chIm = new Float64Array(OFDM.FFT_SIZE);
@@ -439,6 +1332,73 @@ function estimateChannel(receivedSamples, knownRe, knownIm) {
     return [chRe, chIm];
 }
 
+// --- Channel Impulse Response (diagnostics) ---
+// |h(n)| from a channel estimate, unestimated bins interpolated first. It is
+// circular: n > FFT_SIZE/2 are precursors.
+function channelImpulseResponse(chRe, chIm) {
+    const n = OFDM.FFT_SIZE, width = OFDM.SUB_END - OFDM.SUB_START + 1;
+    const hRe = new Float64Array(width), hIm = new Float64Array(width);
+    const known = [];
+    for (let i = 0; i < width; i++) {
+        const k = OFDM.SUB_START + i;
+        hRe[i] = chRe[k]; hIm[i] = chIm[k];
+        if (hRe[i] !== 0 || hIm[i] !== 0) known.push(i);
+    }
+    const impulse = new Float64Array(n);
+    if (known.length === 0) return impulse;
+
+    for (let i = 0, j = 0; i < width; i++) {
+        while (j < known.length - 1 && known[j + 1] <= i) j++;
+        const a = known[j], b = known[Math.min(j + 1, known.length - 1)];
+        if (i === a) continue;
+        const t = b === a ? 0 : Math.min(1, Math.max(0, (i - a) / (b - a)));
+        hRe[i] = hRe[a] + t * (hRe[b] - hRe[a]);
+        hIm[i] = hIm[a] + t * (hIm[b] - hIm[a]);
+    }
+
+    const re = new Float64Array(n), im = new Float64Array(n);
+    for (let i = 0; i < width; i++) {
+        const w = 0.5 - 0.5 * Math.cos(2 * Math.PI * (i + 0.5) / width);
+        re[i] = hRe[i] * w; im[i] = hIm[i] * w;
+    }
+    const [tRe, tIm] = ifft(re, im);
+    for (let i = 0; i < n; i++) impulse[i] = Math.hypot(tRe[i], tIm[i]) * n / width * 2;
+    return impulse;
+}
+
+// RMS and maximum excess delay, in samples, of the taps within floorDb of
+// the strongest. Reverb with a maximum excess delay beyond CP_LEN causes
+// inter-symbol interference no equalizer setting can undo.
+function channelDelaySpread(impulse, floorDb = -20) {
+    const n = impulse.length;
+    let peak = 0, peakIdx = 0;
+    for (let i = 0; i < n; i++) if (impulse[i] > peak) { peak = impulse[i]; peakIdx = i; }
+    if (peak === 0) return { rms: 0, maxExcess: 0 };
+    const floor = peak * Math.pow(10, floorDb / 20);
+
+    let sum = 0, sumT = 0, sumT2 = 0, first = Infinity, last = -Infinity;
+    for (let i = 0; i < n; i++) {
+        if (impulse[i] < floor) continue;
+        const t = ((i - peakIdx + n + n / 2) % n) - n / 2; // delay relative to the peak
+        const p = impulse[i] * impulse[i];
+        sum += p; sumT += p * t; sumT2 += p * t * t;
+        first = Math.min(first, t); last = Math.max(last, t);
+    }
+    const mean = sumT / sum;
+    return { rms: Math.sqrt(Math.max(0, sumT2 / sum - mean * mean)), maxExcess: last - first };
+}
+
+// Reverb check on a frame's channel estimate. Echoes cause no ISI while they
+// end inside the part of the CP ahead of the FFT window (fftWindowStart);
+// warn from REVERB_WARN_RATIO of that on, so a spread right at the limit
+// warns too (the estimate is only good to a few samples).
+const REVERB_WARN_RATIO = 0.75;
+
+function reverbCheck(chRe, chIm) {
+    const delaySpread = channelDelaySpread(channelImpulseResponse(chRe, chIm));
+    return { delaySpread, reverbWarning: delaySpread.maxExcess >= REVERB_WARN_RATIO * OFDM.fftWindowStart() };
+}
+
 // --- CRC-32 ---
 const CRC32_TABLE = (() => {
     const t = new Uint32Array(256);
@@ -456,6 +1416,149 @@ function crc32(data) {
     return (c ^ 0xFFFFFFFF) >>> 0;
 }
 
+// --- Whole-file hash, carried in the metadata frame ---
+// Incremental, so the sender can hash chunk by chunk without holding the file.
+const FILE_HASH = { NONE: 0, CRC32C: 1, SHA256: 2 };
+const FILE_HASH_LEN = { [FILE_HASH.CRC32C]: 4, [FILE_HASH.SHA256]: 32 };
+const FILE_HASH_NAMES = { [FILE_HASH.CRC32C]: 'CRC-32C', [FILE_HASH.SHA256]: 'SHA-256' };
+OFDM.fileHash = FILE_HASH.NONE;
+
+function setFileHash(alg) {
+    OFDM.fileHash = FILE_HASH_LEN[alg] ? alg : FILE_HASH.NONE;
+}
+
+// CRC-32C (Castagnoli, reflected poly 0x82F63B78)
+const CRC32C_TABLE = (() => {
+    const t = new Uint32Array(256);
+    for (let i = 0; i < 256; i++) {
+        let c = i;
+        for (let j = 0; j < 8; j++) c = (c & 1) ? (0x82F63B78 ^ (c >>> 1)) : (c >>> 1);
+        t[i] = c;
+    }
+    return t;
+})();
+
+const SHA256_K = new Uint32Array([
+    0x428a2f98, 0x71374491, 0xb5c0fbcf, 0xe9b5dba5, 0x3956c25b, 0x59f111f1, 0x923f82a4, 0xab1c5ed5,
+    0xd807aa98, 0x12835b01, 0x243185be, 0x550c7dc3, 0x72be5d74, 0x80deb1fe, 0x9bdc06a7, 0xc19bf174,
+    0xe49b69c1, 0xefbe4786, 0x0fc19dc6, 0x240ca1cc, 0x2de92c6f, 0x4a7484aa, 0x5cb0a9dc, 0x76f988da,
+    0x983e5152, 0xa831c66d, 0xb00327c8, 0xbf597fc7, 0xc6e00bf3, 0xd5a79147, 0x06ca6351, 0x14292967,
+    0x27b70a85, 0x2e1b2138, 0x4d2c6dfc, 0x53380d13, 0x650a7354, 0x766a0abb, 0x81c2c92e, 0x92722c85,
+    0xa2bfe8a1, 0xa81a664b, 0xc24b8b70, 0xc76c51a3, 0xd192e819, 0xd6990624, 0xf40e3585, 0x106aa070,
+    0x19a4c116, 0x1e376c08, 0x2748774c, 0x34b0bcb5, 0x391c0cb3, 0x4ed8aa4a, 0x5b9cca4f, 0x682e6ff3,
+    0x748f82ee, 0x78a5636f, 0x84c87814, 0x8cc70208, 0x90befffa, 0xa4506ceb, 0xbef9a3f7, 0xc67178f2,
+]);
+
+// update(bytes) any number of times, then digest() → Uint8Array
+class FileHasher {
+    constructor(alg) {
+        this.alg = alg;
+        this.crc = 0xFFFFFFFF;
+        if (alg === FILE_HASH.SHA256) {
+            this.h = new Uint32Array([0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a, 0x510e527f, 0x9b05688c, 0x1f83d9ab, 0x5be0cd19]);
+            this.block = new Uint8Array(64);
+            this.blockLen = 0;
+            this.length = 0;
+            this.w = new Uint32Array(64);
+        }
+    }
+
+    update(data) {
+        if (this.alg === FILE_HASH.CRC32C) {
+            let c = this.crc;
+            for (const b of data) c = CRC32C_TABLE[(c ^ b) & 0xFF] ^ (c >>> 8);
+            this.crc = c;
+        } else if (this.alg === FILE_HASH.SHA256) {
+            this.length += data.length;
+            for (let i = 0; i < data.length; i++) {
+                this.block[this.blockLen++] = data[i];
+                if (this.blockLen === 64) { this._compress(); this.blockLen = 0; }
+            }
+        }
+        return this;
+    }
+
+    digest() {
+        if (this.alg === FILE_HASH.CRC32C) {
+            const c = (this.crc ^ 0xFFFFFFFF) >>> 0;
+            return new Uint8Array([(c >>> 24) & 0xFF, (c >> 16) & 0xFF, (c >> 8) & 0xFF, c & 0xFF]);
+        }
+        if (this.alg !== FILE_HASH.SHA256) return new Uint8Array(0);
+        const bits = this.length * 8;
+        const pad = new Uint8Array((this.blockLen < 56 ? 56 : 120) - this.blockLen + 8);
+        pad[0] = 0x80;
+        const hi = Math.floor(bits / 0x100000000), lo = bits >>> 0;
+        for (let i = 0; i < 4; i++) {
+            pad[pad.length - 8 + i] = (hi >>> (24 - 8 * i)) & 0xFF;
+            pad[pad.length - 4 + i] = (lo >>> (24 - 8 * i)) & 0xFF;
+        }
+        // Pad a copy of the state, so digest() can be repeated or followed by more updates
+        const saved = { h: this.h.slice(), block: this.block.slice(), blockLen: this.blockLen, length: this.length };
+        this.update(pad);
+        const out = new Uint8Array(32);
+        for (let i = 0; i < 8; i++) {
+            out[4 * i] = this.h[i] >>> 24;
+            out[4 * i + 1] = (this.h[i] >> 16) & 0xFF;
+            out[4 * i + 2] = (this.h[i] >> 8) & 0xFF;
+            out[4 * i + 3] = this.h[i] & 0xFF;
+        }
+        Object.assign(this, saved);
+        return out;
+    }
+
+    _compress() {
+        const w = this.w, b = this.block, h = this.h;
+        for (let i = 0; i < 16; i++) w[i] = (b[4 * i] << 24) | (b[4 * i + 1] << 16) | (b[4 * i + 2] << 8) | b[4 * i + 3];
+        for (let i = 16; i < 64; i++) {
+            const x = w[i - 15], y = w[i - 2];
+            const s0 = ((x >>> 7) | (x << 25)) ^ ((x >>> 18) | (x << 14)) ^ (x >>> 3);
+            const s1 = ((y >>> 17) | (y << 15)) ^ ((y >>> 19) | (y << 13)) ^ (y >>> 10);
+            w[i] = w[i - 16] + s0 + w[i - 7] + s1;
+        }
+        let [a, bb, c, d, e, f, g, hh] = h;
+        for (let i = 0; i < 64; i++) {
+            const S1 = ((e >>> 6) | (e << 26)) ^ ((e >>> 11) | (e << 21)) ^ ((e >>> 25) | (e << 7));
+            const t1 = (hh + S1 + ((e & f) ^ (~e & g)) + SHA256_K[i] + w[i]) | 0;
+            const S0 = ((a >>> 2) | (a << 30)) ^ ((a >>> 13) | (a << 19)) ^ ((a >>> 22) | (a << 10));
+            const t2 = (S0 + ((a & bb) ^ (a & c) ^ (bb & c))) | 0;
+            hh = g; g = f; f = e; e = (d + t1) | 0;
+            d = c; c = bb; bb = a; a = (t1 + t2) | 0;
+        }
+        h[0] += a; h[1] += bb; h[2] += c; h[3] += d;
+        h[4] += e; h[5] += f; h[6] += g; h[7] += hh;
+    }
+}
+
+function hashBytes(alg, data) {
+    return new FileHasher(alg).update(data).digest();
+}
+
+function hashesEqual(a, b) {
+    return a.length === b.length && a.every((v, i) => v === b[i]);
+}
+
+// --- CRC-8 (poly 0x07), for the short frame header ---
+function crc8(data) {
+    let c = 0;
+    for (const b of data) {
+        c ^= b;
+        for (let j = 0; j < 8; j++) c = (c & 0x80) ? ((c << 1) ^ 0x07) & 0xFF : (c << 1) & 0xFF;
+    }
+    return c;
+}
+
+// --- Decode Failure Reasons ---
+// Attached to failed chunk-frame results (result.reason) so receivers can
+// tell noise (crc, truncated) from a configuration mismatch (header, frame-type).
+const DECODE_FAIL = {
+    HEADER: 'header',         // frame header CRC failed — wrong modulation/config, or noise
+    TRUNCATED: 'truncated',   // frame shorter than its header/length fields claim
+    FRAME_TYPE: 'frame-type', // unknown frame type byte, or not the kind of frame asked for
+    CRC: 'crc',               // payload CRC-32 mismatch
+    VERSION: 'version',       // frame from a newer protocol version
+    LENGTH: 'length',         // chunk length disagrees with the metadata
+};
+
 // --- Byte/Bit Conversion ---
 function bytesToBits(data) {
     const bits = [];
@@ -523,84 +1626,70 @@ function buildTransmitSignal(fileData, modName, fileName, repetition) {
 
     let bits = bytesToBits(payload);
     if (repetition > 1) bits = repeatBits(bits, repetition);
+    if (bits.length > MAX_FRAME_BITS) return { error: 'Too large for a single frame; use chunked transfer' };
     const { samples, numSymbols, bitsPerSymbol } = modulateOFDM(bits, modName);
 
     // Build full signal: silence + preamble + CE + data + silence
-    const pre1 = generatePreambleSymbol1();
-    const pre2 = generatePreambleSymbol2();
+    const preamble = generatePreambleTrain();
     const ce = generateChannelEstSymbol();
 
-    const isAcoustic = OFDM.CP_LEN >= 128;
-    const silencePre = new Float32Array(OFDM.SAMPLE_RATE * (isAcoustic ? 0.5 : 0.3));
-    const silencePost = new Float32Array(OFDM.SAMPLE_RATE * (isAcoustic ? 0.5 : 0.2));
+    const silencePre = new Float32Array(frameGuardSamples('lead'));
+    const silencePost = new Float32Array(frameGuardSamples('trail'));
 
-    let totalLen = silencePre.length + pre1.length + pre2.length + ce.samples.length + silencePost.length;
+    let totalLen = silencePre.length + preamble.length + ce.samples.length + silencePost.length;
     for (const s of samples) totalLen += s.length;
 
     const signal = new Float32Array(totalLen);
     let off = 0;
     signal.set(silencePre, off); off += silencePre.length;
-    signal.set(pre1, off); off += pre1.length;
-    signal.set(pre2, off); off += pre2.length;
+    signal.set(preamble, off); off += preamble.length;
     signal.set(ce.samples, off); off += ce.samples.length;
     for (const s of samples) { signal.set(s, off); off += s.length; }
     signal.set(silencePost, off);
 
-    // Normalize entire signal uniformly (critical for channel estimation)
-    let mx = 0;
-    for (let i = 0; i < signal.length; i++) mx = Math.max(mx, Math.abs(signal[i]));
-    if (mx > 0) { const s = 0.8 / mx; for (let i = 0; i < signal.length; i++) signal[i] *= s; }
+    // One gain for the entire signal (critical for channel estimation)
+    scaleToOutputAmplitude(signal);
 
-    return { signal, numSymbols, bitsPerSymbol, totalBits: bits.length, dataLen: len };
+    return { signal: withCalibrationChirp(signal), numSymbols, bitsPerSymbol, totalBits: bits.length, dataLen: len };
 }
 
+// Tries the preamble candidates best first and returns the first frame that
+// passes its CRC; if none does, the best candidate's result (a CRC-failed
+// frame ahead of an error), so a lone damaged frame is still reported.
 function decodeReceivedSignal(signal, modName, repetition) {
     repetition = repetition || 1;
     // Preprocess: DC removal + normalize
     signal = preprocessSignal(signal);
 
     // Step 1: Coarse preamble detection (Schmidl-Cox auto-correlation, O(n))
-    let coarseIdx = detectPreamble(signal);
-    if (coarseIdx < 0) return { error: 'Preamble not detected' };
+    const candidates = detectPreambleCandidates(signal);
+    if (candidates.length === 0) return { error: 'Preamble not detected' };
+
+    let fallback = null;
+    for (const coarseIdx of candidates) {
+        const result = decodeFrameAtPreamble(signal, coarseIdx, modName, repetition);
+        if (!result.error && result.crcValid) return result;
+        if (!fallback || (fallback.error && !result.error)) fallback = result;
+    }
+    return fallback;
+}
 
+function decodeFrameAtPreamble(signal, coarseIdx, modName, repetition) {
     // Step 2: Fine-tune with cross-correlation around coarse estimate
-    const pre1 = generatePreambleSymbol1();
-    let tEnergy = 0;
-    for (let i = 0; i < pre1.length; i++) tEnergy += pre1[i] * pre1[i];
-
-    const searchRadius = OFDM.CP_LEN * 3;
-    const fineStart = Math.max(0, coarseIdx - searchRadius);
-    const fineEnd = Math.min(signal.length - pre1.length, coarseIdx + searchRadius);
-
-    let bestMetric = -Infinity, startIdx = coarseIdx;
-    for (let d = fineStart; d <= fineEnd; d++) {
-        let corr = 0, sEnergy = 0;
-        for (let i = 0; i < pre1.length; i++) {
-            corr += signal[d + i] * pre1[i];
-            sEnergy += signal[d + i] * signal[d + i];
-        }
-        const denom = Math.sqrt(sEnergy * tEnergy);
-        if (denom > 0.001) {
-            const metric = corr / denom;
-            if (metric > bestMetric) { bestMetric = metric; startIdx = d; }
-        }
-    }
+    const { index: startIdx, metric: bestMetric } = refinePreambleCrossCorr(signal, coarseIdx);
     if (bestMetric < 0.1) return { error: 'Preamble not detected (low correlation)' };
 
     // Channel estimation
     const ceStart = startIdx + 2 * OFDM.SYMBOL_LEN;
     if (ceStart + OFDM.SYMBOL_LEN > signal.length) return { error: 'Signal too short for CE' };
 
-    const ceSamples = signal.slice(ceStart, ceStart + OFDM.SYMBOL_LEN);
-    const ce = generateChannelEstSymbol();
-    const [chRe, chIm] = estimateChannel(ceSamples, ce.knownRe, ce.knownIm);
-
     // Demodulate data
     const dataStart = ceStart + OFDM.SYMBOL_LEN;
     if (dataStart >= signal.length) return { error: 'No data after CE' };
 
-    const dataSamples = signal.slice(dataStart);
-    let bits = demodulateOFDM(dataSamples, modName, chRe, chIm);
+    const { demod, chRe, chIm } = demodulateAfterCE(signal, ceStart, modName);
+    if (demod.error) return { error: demod.error, reason: demod.reason };
+    let bits = demod.bits;
     if (repetition > 1) bits = majorityVote(bits, repetition);
     const bytes = bitsToBytes(bits);
 
@@ -618,6 +1707,12 @@ function decodeReceivedSignal(signal, modName, repetition) {
         result.preambleIdx = startIdx;
         return result;
     }
+    // 0xFB is also a legacy packet with a 251-byte name; only a valid
+    // message frame is taken as one
+    if (firstByte === FRAME_MESSAGE) {
+        const result = parseMessageResult(bytes);
+        if (result.crcValid) return { ...result, preambleIdx: startIdx };
+    }
 
     // Legacy packet: [nameLen:1][name:N][dataLen:4][data][CRC-32:4]
     let off = 0;
@@ -625,13 +1720,14 @@ function decodeReceivedSignal(signal, modName, repetition) {
     if (off + nameLen + 4 + 4 > bytes.length) return { error: 'Decoded data too short for header' };
 
     let fileName = '';
-    try { fileName = new TextDecoder().decode(bytes.slice(off, off + nameLen)); } catch(e) {}
+    try { fileName = sanitizeFileName(new TextDecoder().decode(bytes.slice(off, off + nameLen))); } catch(e) {}
     off += nameLen;
 
     const dataLen = (bytes[off] << 24) | (bytes[off+1] << 16) | (bytes[off+2] << 8) | bytes[off+3];
     off += 4;
 
-    if (dataLen <= 0 || off + dataLen + 4 > bytes.length) return { error: `Invalid data length: ${dataLen}` };
+    // dataLen 0 is an empty file, not an error
+    if (dataLen < 0 || off + dataLen + 4 > bytes.length) return { error: `Invalid data length: ${dataLen}` };
 
     const fileData = bytes.slice(off, off + dataLen);
     off += dataLen;
@@ -650,9 +1746,347 @@ function decodeReceivedSignal(signal, modName, repetition) {
         actualCRC,
         preambleIdx: startIdx,
         frameType: 'legacy',
+        suggestedMask: demod.suggestedMask,
+        evm: demod.evm,
+        ...reverbCheck(chRe, chIm),
     };
 }
 
+// ============================================================
+// Modem Facade — Encode/Decode Without Audio I/O
+// ============================================================
+
+// Transfer modes as offered in the UI (and the CLI)
+function getModemParams(mod) {
+    if (mod === 'BPSK-ACOUSTIC') return { config: 'acoustic', modName: 'BPSK', repetition: 1 };
+    if (mod === 'BPSK-REPEAT') return { config: 'acoustic', modName: 'BPSK', repetition: 3 };
+    if (mod === 'BPSK-NARROW') return { config: 'narrowband', modName: 'BPSK', repetition: 3 };
+    if (mod === 'BPSK-ULTRASONIC') return { config: 'ultrasonic', modName: 'BPSK', repetition: 1 };
+    // The message itself goes by MFSKModem; OFDM extras (tests, beacons) use acoustic BPSK
+    if (mod === 'MFSK') return { config: 'acoustic', modName: 'BPSK', repetition: 1 };
+    if (mod === '16-QAM') return { config: 'standard', modName: 'QAM16', repetition: 1 };
+    return { config: 'standard', modName: 'QPSK', repetition: 1 };
+}
+
+// Bundles an OFDM config, constellation and repetition factor so callers
+// don't have to wire preamble, CE, modulation and coding together by hand.
+// The OFDM parameters are global, so every call re-applies this modem's config.
+class Modem {
+    constructor(configName, modName, repetition) {
+        this.configName = OFDM_CONFIGS[configName] ? configName : 'standard';
+        this.modName = Constellations[modName] ? modName : 'QPSK';
+        this.repetition = repetition || 1;
+    }
+
+    // data: Uint8Array → Float32Array of audio samples at OFDM.SAMPLE_RATE,
+    // or { error } if it doesn't fit one frame (encodeFile has no limit)
+    encode(data, fileName) {
+        setOFDMConfig(this.configName);
+        const built = buildTransmitSignal(data, this.modName, fileName, this.repetition);
+        return built.error ? built : built.signal;
+    }
+
+    // samples → { data, fileName } or { error }. A recording that opens with
+    // another kind of frame fails with reason DECODE_FAIL.FRAME_TYPE and
+    // reports its frameType (e.g. FRAME_MESSAGE).
+    decode(samples) {
+        setOFDMConfig(this.configName);
+        const result = decodeReceivedSignal(samples, this.modName, this.repetition);
+        if (result.error) return { error: result.error, reason: result.reason };
+        if (result.frameType !== 'legacy') {
+            return { error: 'Not a single-frame transmission', reason: DECODE_FAIL.FRAME_TYPE, frameType: result.frameType };
+        }
+        if (!result.crcValid) return { error: 'CRC mismatch', data: result.data, fileName: result.fileName };
+        return { data: result.data, fileName: result.fileName };
+    }
+
+    // Whole file in the chunked protocol: metadata frame + one frame per
+    // chunk (+ a parity frame per OFDM.parityGroup chunks), as the app sends
+    // it, at any size. { error } for a chunk size the receivers can't take.
+    encodeFile(data, fileName, chunkSize = getChunkSize(this.modName)) {
+        if (!Number.isInteger(chunkSize) || chunkSize < MIN_CHUNK_SIZE || chunkSize > MAX_CHUNK_SIZE) {
+            return { error: `Chunk size must be ${MIN_CHUNK_SIZE}..${MAX_CHUNK_SIZE} bytes` };
+        }
+        setOFDMConfig(this.configName);
+        const totalChunks = Math.ceil(data.length / chunkSize);
+        const group = OFDM.parityGroup;
+        const fileHash = OFDM.fileHash ? { alg: OFDM.fileHash, digest: hashBytes(OFDM.fileHash, data) } : null;
+        const frames = [buildMetadataFrame(totalChunks, data.length, chunkSize, fileName, this.modName, this.repetition, fileHash)];
+        let parity = null, groupStart = 0;
+        for (let seq = 0; seq < totalChunks; seq++) {
+            const chunk = data.subarray(seq * chunkSize, (seq + 1) * chunkSize);
+            frames.push(buildDataChunkFrame(chunk, seq, this.modName, this.repetition));
+            if (!group) continue;
+            if (!parity) { parity = new Uint8Array(chunkSize); groupStart = seq; }
+            xorInto(parity, chunk);
+            if (seq - groupStart + 1 === group || seq === totalChunks - 1) {
+                frames.push(buildParityFrame(groupStart, seq - groupStart + 1, parity, this.modName, this.repetition));
+                parity = null;
+            }
+        }
+        let totalLen = 0;
+        for (const f of frames) totalLen += f.length;
+        const signal = new Float32Array(totalLen);
+        let off = 0;
+        for (const f of frames) { signal.set(f, off); off += f.length; }
+        return signal;
+    }
+
+    // Recording of a chunked transmission → { data, fileName }, or { error }
+    // with the sequence numbers still missing. Frames may arrive in any order
+    // or more than once.
+    decodeFile(samples) {
+        setOFDMConfig(this.configName);
+        const file = assembleChunkFrames(decodeFrames(samples, this.modName, this.repetition));
+        if (file.error) return file;
+        return { data: file.data, fileName: file.fileName };
+    }
+
+    // Short non-file payload as one tagged frame → { signal }, or { error }
+    // if data and content type come to more than MAX_MESSAGE_BYTES
+    encodeMessage(data, contentType = 'text/plain') {
+        setOFDMConfig(this.configName);
+        const signal = buildMessageFrame(data, contentType, this.modName, this.repetition);
+        return signal.error ? signal : { signal };
+    }
+
+    // Recording → { data, contentType } of the first intact message, or
+    // { error } (a file transfer holds no message frame)
+    decodeMessage(samples) {
+        setOFDMConfig(this.configName);
+        const frames = decodeFrames(samples, this.modName, this.repetition);
+        const msg = frames.find(f => f.frameType === FRAME_MESSAGE && f.crcValid);
+        if (!msg) {
+            const damaged = frames.some(f => f.frameType === FRAME_MESSAGE);
+            return { error: damaged ? 'Message CRC mismatch' : 'No message in the recording' };
+        }
+        return { data: msg.data, contentType: msg.contentType };
+    }
+
+    // Benchmark: random bits through modulate → channel → AWGN (snrDb inside
+    // the data band) → sync → demodulate. Lost bits count as errors.
+    measureBER({ snrDb = Infinity, numBits = 8192, channel = null } = {}) {
+        setOFDMConfig(this.configName);
+        const payload = new Uint8Array(Math.ceil(numBits / 8));
+        for (let i = 0; i < payload.length; i++) payload[i] = Math.floor(Math.random() * 256);
+        const sent = bytesToBits(payload);
+
+        let signal = buildChunkOFDMFrame(payload, this.modName, this.repetition, true);
+        const lead = frameGuardSamples('lead');
+        const core = estimateFrameSamples(payload.length, this.modName, this.repetition);
+        if (channel) signal = Float32Array.from(channel(signal));
+        if (Number.isFinite(snrDb)) {
+            let power = 0;
+            for (let i = lead; i < lead + core; i++) power += signal[i] * signal[i];
+            power /= core;
+            const bandShare = (OFDM.SUB_END - OFDM.SUB_START + 1) / (OFDM.FFT_SIZE / 2);
+            const sigma = Math.sqrt(power / Math.pow(10, snrDb / 10) / bandShare);
+            for (let i = 0; i < signal.length; i++) {
+                const u = Math.random() || 1e-12, v = Math.random();
+                signal[i] += sigma * Math.sqrt(-2 * Math.log(u)) * Math.cos(2 * Math.PI * v);
+            }
+        }
+
+        let received = [];
+        const rx = preprocessSignal(signal);
+        const coarse = detectPreamble(rx);
+        if (coarse >= 0) {
+            const { index } = refinePreambleCrossCorr(rx, coarse);
+            const { demod } = demodulateAfterCE(rx, index + 2 * OFDM.SYMBOL_LEN, this.modName);
+            if (demod.bits) received = this.repetition > 1 ? majorityVote(demod.bits, this.repetition) : demod.bits;
+        }
+
+        let bitErrors = 0;
+        for (let i = 0; i < sent.length; i++) if (i >= received.length || received[i] !== sent[i]) bitErrors++;
+        return {
+            ber: bitErrors / sent.length,
+            bitErrors,
+            bits: sent.length,
+            bitRate: sent.length * OFDM.SAMPLE_RATE / core,
+        };
+    }
+
+    // Loss-free rates in bits/s: rawBitRate while data symbols play, netBitRate
+    // of file data from encodeFile with full chunks after every per-frame cost.
+    // There is no ARQ, so no retransmissions are counted.
+    dataRate(chunkSize = getChunkSize(this.modName)) {
+        setOFDMConfig(this.configName);
+        const bps = Constellations[this.modName].bps;
+        const rawBitRate = OFDM.dataSubcarriers().length * bps * OFDM.SAMPLE_RATE / OFDM.SYMBOL_LEN;
+        // [0xFF][seq:4][len:2][data][CRC-32], parity adds a count byte
+        let samples = estimateFrameSamplesWithSilence(chunkSize + 11, this.modName, this.repetition, false);
+        if (OFDM.parityGroup) {
+            samples += estimateFrameSamplesWithSilence(chunkSize + 12, this.modName, this.repetition, false) / OFDM.parityGroup;
+        }
+        return { rawBitRate, netBitRate: chunkSize * 8 * OFDM.SAMPLE_RATE / samples };
+    }
+
+    get sampleRate() {
+        return OFDM_CONFIGS[this.configName].SAMPLE_RATE;
+    }
+}
+
+// ============================================================
+// MFSK Fallback — One Tone at a Time
+// ============================================================
+// For links where OFDM won't even sync: 8 orthogonal tones, one per 40 ms
+// symbol, 3 bits each, after a Costas sequence that marks the start.
+// Frame: [Costas][dataLen:2][nameLen:1][name][data][CRC-32:4]
+const MFSK_SYMBOL_LEN = 1764;    // 40 ms at 44.1 kHz → 25 Hz bins
+const MFSK_BASE_BIN = 60;        // 1500 Hz
+const MFSK_BIN_SPACING = 4;      // 100 Hz between tones
+const MFSK_TONES = 8;
+const MFSK_BITS = 3;
+const MFSK_COSTAS = [3, 1, 4, 0, 6, 5, 2];
+const MFSK_MAX_BYTES = 256;
+const MFSK_HOP = 63;             // timing search step, MFSK_SYMBOL_LEN / 28
+const MFSK_SYNC_MIN = 0.4;       // share of energy on the Costas tones (noise: 1/8)
+
+function mfskToneFreq(tone) {
+    return (MFSK_BASE_BIN + tone * MFSK_BIN_SPACING) * OFDM.SAMPLE_RATE / MFSK_SYMBOL_LEN;
+}
+
+function buildMFSKSignal(data, fileName) {
+    if (data.length > MFSK_MAX_BYTES) return { error: `MFSK carries at most ${MFSK_MAX_BYTES} bytes` };
+    const name = new TextEncoder().encode(fileName || '').slice(0, 255);
+    const body = new Uint8Array(3 + name.length + data.length + 4);
+    body[0] = (data.length >> 8) & 0xFF;
+    body[1] = data.length & 0xFF;
+    body[2] = name.length;
+    body.set(name, 3);
+    body.set(data, 3 + name.length);
+    const checksum = crc32(body.subarray(0, body.length - 4));
+    for (let i = 0; i < 4; i++) body[body.length - 4 + i] = (checksum >>> (24 - 8 * i)) & 0xFF;
+
+    const bits = bytesToBits(body);
+    const tones = [...MFSK_COSTAS];
+    for (let i = 0; i < bits.length; i += MFSK_BITS) {
+        let t = 0;
+        for (let b = 0; b < MFSK_BITS; b++) t = (t << 1) | (bits[i + b] || 0);
+        tones.push(t);
+    }
+
+    const lead = frameGuardSamples('lead'), tail = frameGuardSamples('trail');
+    const signal = new Float32Array(lead + tones.length * MFSK_SYMBOL_LEN + tail);
+    tones.forEach((t, k) => {
+        const w = 2 * Math.PI * mfskToneFreq(t) / OFDM.SAMPLE_RATE;
+        const off = lead + k * MFSK_SYMBOL_LEN;
+        for (let n = 0; n < MFSK_SYMBOL_LEN; n++) signal[off + n] = OFDM.outputAmplitude * Math.sin(w * n);
+    });
+    return signal;
+}
+
+// Energy of each tone over one symbol starting at every MFSK_HOP samples:
+// energies[tone][j] for the window at j * MFSK_HOP
+function mfskToneEnergies(samples) {
+    const steps = MFSK_SYMBOL_LEN / MFSK_HOP;
+    const numWin = Math.floor((samples.length - MFSK_SYMBOL_LEN) / MFSK_HOP) + 1;
+    const energies = [];
+    for (let t = 0; t < MFSK_TONES; t++) {
+        const w = 2 * Math.PI * mfskToneFreq(t) / OFDM.SAMPLE_RATE;
+        // Running sums of x·e^{-jwn}, kept at every hop; the phasor is
+        // rotated per sample rather than calling cos/sin
+        const numHops = Math.floor(samples.length / MFSK_HOP) + 1;
+        const sumRe = new Float64Array(numHops), sumIm = new Float64Array(numHops);
+        const cw = Math.cos(w), sw = Math.sin(w);
+        let re = 0, im = 0, pc = 1, ps = 0;
+        for (let n = 0; n < samples.length; n++) {
+            if (n % MFSK_HOP === 0) { sumRe[n / MFSK_HOP] = re; sumIm[n / MFSK_HOP] = im; }
+            re += samples[n] * pc;
+            im -= samples[n] * ps;
+            const next = pc * cw - ps * sw;
+            ps = pc * sw + ps * cw;
+            pc = next;
+        }
+        if (samples.length % MFSK_HOP === 0) { sumRe[numHops - 1] = re; sumIm[numHops - 1] = im; }
+        const e = new Float32Array(Math.max(0, numWin));
+        for (let j = 0; j < numWin; j++) {
+            const dr = sumRe[j + steps] - sumRe[j], di = sumIm[j + steps] - sumIm[j];
+            e[j] = dr * dr + di * di;
+        }
+        energies.push(e);
+    }
+    return energies;
+}
+
+// samples → { data, fileName, crcValid, syncMetric } or { error }
+function decodeMFSKSignal(samples) {
+    const energies = mfskToneEnergies(samples);
+    const steps = MFSK_SYMBOL_LEN / MFSK_HOP;
+    const numWin = energies[0].length;
+    const toneAt = (j) => {
+        let best = 0;
+        for (let t = 1; t < MFSK_TONES; t++) if (energies[t][j] > energies[best][j]) best = t;
+        return best;
+    };
+
+    // Sync: the window where the Costas tones hold the largest energy share
+    let syncJ = -1, syncMetric = 0;
+    for (let j = 0; j + (MFSK_COSTAS.length - 1) * steps < numWin; j++) {
+        let on = 0, all = 0;
+        MFSK_COSTAS.forEach((tone, k) => {
+            const jk = j + k * steps;
+            on += energies[tone][jk];
+            for (let t = 0; t < MFSK_TONES; t++) all += energies[t][jk];
+        });
+        const metric = all > 0 ? on / all : 0;
+        if (metric > syncMetric) { syncMetric = metric; syncJ = j; }
+    }
+    if (syncJ < 0 || syncMetric < MFSK_SYNC_MIN) return { error: 'No MFSK sync found', reason: DECODE_FAIL.HEADER };
+
+    const first = syncJ + MFSK_COSTAS.length * steps;
+    const readBytes = (fromByte, count) => {
+        const bits = [];
+        const startSym = Math.floor(fromByte * 8 / MFSK_BITS);
+        const endSym = Math.ceil((fromByte + count) * 8 / MFSK_BITS);
+        for (let k = startSym; k < endSym; k++) {
+            const j = first + k * steps;
+            if (j >= numWin) return null;
+            const t = toneAt(j);
+            for (let b = MFSK_BITS - 1; b >= 0; b--) bits.push((t >> b) & 1);
+        }
+        const skip = fromByte * 8 - startSym * MFSK_BITS;
+        return bitsToBytes(bits.slice(skip, skip + count * 8));
+    };
+
+    const head = readBytes(0, 3);
+    if (!head) return { error: 'MFSK frame truncated', reason: DECODE_FAIL.TRUNCATED };
+    const dataLen = (head[0] << 8) | head[1], nameLen = head[2];
+    if (dataLen > MFSK_MAX_BYTES) return { error: 'MFSK length field corrupted', reason: DECODE_FAIL.CRC };
+    const total = 3 + nameLen + dataLen + 4;
+    const body = readBytes(0, total);
+    if (!body) return { error: 'MFSK frame truncated', reason: DECODE_FAIL.TRUNCATED };
+
+    const expectedCRC = ((body[total - 4] << 24) | (body[total - 3] << 16) | (body[total - 2] << 8) | body[total - 1]) >>> 0;
+    const actualCRC = crc32(body.subarray(0, total - 4));
+    return {
+        data: body.slice(3 + nameLen, 3 + nameLen + dataLen),
+        fileName: sanitizeFileName(new TextDecoder().decode(body.subarray(3, 3 + nameLen))),
+        crcValid: expectedCRC === actualCRC,
+        syncMetric,
+    };
+}
+
+// Modem's encode/decode for the MFSK fallback; messages up to MFSK_MAX_BYTES
+class MFSKModem {
+    // data: Uint8Array → Float32Array of audio samples, or { error }
+    encode(data, fileName) {
+        return buildMFSKSignal(data, fileName);
+    }
+
+    // samples → { data, fileName } or { error }
+    decode(samples) {
+        const result = decodeMFSKSignal(samples);
+        if (result.error) return { error: result.error };
+        if (!result.crcValid) return { error: 'CRC mismatch', data: result.data, fileName: result.fileName };
+        return { data: result.data, fileName: result.fileName };
+    }
+
+    get sampleRate() {
+        return OFDM.SAMPLE_RATE;
+    }
+}
+
 // ============================================================
 // Chunked Transfer Protocol — Large File Support
 // ============================================================
@@ -660,14 +2094,58 @@ function decodeReceivedSignal(signal, modName, repetition) {
 // Frame type magic bytes
 const FRAME_META = 0xFE;
 const FRAME_DATA = 0xFF;
+const FRAME_PARITY = 0xFD;
+const FRAME_BEACON = 0xFC;
+const FRAME_MESSAGE = 0xFB;
+
+const CHUNK_THRESHOLD = 32 * 1024; // 32KB — 이 이하는 레거시, 이상은 청크
+
+// Chunk payload size per constellation, unless OFDM.chunkSize overrides it
+// (null = default). The receiver reads it from the metadata frame.
+const MIN_CHUNK_SIZE = 64;
+const MAX_CHUNK_SIZE = 4096;  // streaming receivers size their buffer for this
+const CHUNK_FRAME_OVERHEAD = 16; // frame type, fields and CRC around a chunk (at most)
+const MAX_FRAME_PAYLOAD = MAX_CHUNK_SIZE + CHUNK_FRAME_OVERHEAD;
+
+// A false preamble lock passes the header's CRC-8 about once in 256 tries,
+// and its 20-bit length can then claim a frame of up to a megabit. No
+// chunk frame carries more than MAX_FRAME_PAYLOAD bytes.
+function chunkFrameTooLong(header, repetition) {
+    return header.totalBits > MAX_FRAME_PAYLOAD * 8 * (repetition || 1);
+}
+OFDM.chunkSize = null;
+
+function setChunkSize(bytes) {
+    OFDM.chunkSize = Number.isInteger(bytes)
+        ? Math.max(MIN_CHUNK_SIZE, Math.min(MAX_CHUNK_SIZE, bytes)) : null;
+}
+
+// Cross-frame parity: a frame with the XOR of every OFDM.parityGroup data
+// chunks (zero-padded), which rebuilds any one lost chunk of the group. 0 = off.
+const MAX_PARITY_GROUP = 32;
+OFDM.parityGroup = 0;
+
+function setParityGroup(n) {
+    OFDM.parityGroup = Number.isInteger(n) && n >= 2 ? Math.min(n, MAX_PARITY_GROUP) : 0;
+}
+
+function getChunkSize(modName) {
+    if (OFDM.chunkSize !== null) return OFDM.chunkSize;
+    if (modName === 'QAM16') return 4096;
+    if (modName === 'QPSK') return 2048;
+    return 512; // BPSK
+}
 
 // --- Chunk Frame Payload Builders ---
 
-function buildMetadataPayload(totalChunks, totalFileSize, chunkSize, fileName) {
+// fileHash: { alg, digest } or null. It goes after the original CRC with a
+// CRC of its own, where receivers that predate it never look:
+// [...][CRC-32:4][hashAlg:1][hash:N][CRC-32:4]
+function buildMetadataPayload(totalChunks, totalFileSize, chunkSize, fileName, fileHash) {
     const nameBytes = new TextEncoder().encode(fileName || 'file');
     const nameLen = Math.min(nameBytes.length, 255);
     // [0xFE:1][totalChunks:4][totalFileSize:4][chunkSize:2][fileNameLen:1][fileName:N][CRC-32:4]
-    const size = 1 + 4 + 4 + 2 + 1 + nameLen + 4;
+    const size = 1 + 4 + 4 + 2 + 1 + nameLen + 4 + (fileHash ? 1 + fileHash.digest.length + 4 : 0);
     const buf = new Uint8Array(size);
     let off = 0;
     buf[off++] = FRAME_META;
@@ -688,6 +2166,15 @@ function buildMetadataPayload(totalChunks, totalFileSize, chunkSize, fileName) {
     buf[off++] = (checksum >> 16) & 0xFF;
     buf[off++] = (checksum >> 8) & 0xFF;
     buf[off++] = checksum & 0xFF;
+    if (fileHash) {
+        buf[off++] = fileHash.alg;
+        buf.set(fileHash.digest, off); off += fileHash.digest.length;
+        const extChecksum = crc32(buf.subarray(0, off));
+        buf[off++] = (extChecksum >> 24) & 0xFF;
+        buf[off++] = (extChecksum >> 16) & 0xFF;
+        buf[off++] = (extChecksum >> 8) & 0xFF;
+        buf[off++] = extChecksum & 0xFF;
+    }
     return buf;
 }
 
@@ -719,44 +2206,41 @@ function buildChunkOFDMFrame(payload, modName, repetition, isFirstFrame) {
     repetition = repetition || 1;
     let bits = bytesToBits(payload);
     if (repetition > 1) bits = repeatBits(bits, repetition);
-    const { samples } = modulateOFDM(bits, modName);
+    return buildFrameSignal(bits, modName, isFirstFrame);
+}
 
-    const pre1 = generatePreambleSymbol1();
-    const pre2 = generatePreambleSymbol2();
+// [silence][preamble1 × preambleRepeats][preamble2][CE][header + data symbols][tail]
+function buildFrameSignal(bits, modName, isFirstFrame, beacon = false) {
+    const { samples } = modulateOFDM(bits, modName, beacon);
+
+    const preamble = generatePreambleTrain();
     const ce = generateChannelEstSymbol();
 
-    const isAcoustic = OFDM.CP_LEN >= 128;
     // First frame (metadata) uses longer silence for initial sync
-    const silencePreLen = isFirstFrame
-        ? Math.round(OFDM.SAMPLE_RATE * (isAcoustic ? 0.5 : 0.3))
-        : Math.round(OFDM.SAMPLE_RATE * 0.05);
-    const silencePostLen = Math.round(OFDM.SAMPLE_RATE * 0.02);
+    const silencePreLen = frameGuardSamples(isFirstFrame ? 'lead' : 'gap');
+    const silencePostLen = Math.round(OFDM.SAMPLE_RATE * CHUNK_FRAME_TAIL);
 
     const silencePre = new Float32Array(silencePreLen);
     const silencePost = new Float32Array(silencePostLen);
 
-    let totalLen = silencePre.length + pre1.length + pre2.length + ce.samples.length + silencePost.length;
+    let totalLen = silencePre.length + preamble.length + ce.samples.length + silencePost.length;
     for (const s of samples) totalLen += s.length;
 
     const signal = new Float32Array(totalLen);
     let off = 0;
     signal.set(silencePre, off); off += silencePre.length;
-    signal.set(pre1, off); off += pre1.length;
-    signal.set(pre2, off); off += pre2.length;
+    signal.set(preamble, off); off += preamble.length;
     signal.set(ce.samples, off); off += ce.samples.length;
     for (const s of samples) { signal.set(s, off); off += s.length; }
     signal.set(silencePost, off);
 
-    // Normalize
-    let mx = 0;
-    for (let i = 0; i < signal.length; i++) mx = Math.max(mx, Math.abs(signal[i]));
-    if (mx > 0) { const s = 0.8 / mx; for (let i = 0; i < signal.length; i++) signal[i] *= s; }
+    scaleToOutputAmplitude(signal);
 
-    return signal;
+    return isFirstFrame ? withCalibrationChirp(signal) : signal;
 }
 
-function buildMetadataFrame(totalChunks, totalFileSize, chunkSize, fileName, modName, rep) {
-    const payload = buildMetadataPayload(totalChunks, totalFileSize, chunkSize, fileName);
+function buildMetadataFrame(totalChunks, totalFileSize, chunkSize, fileName, modName, rep, fileHash) {
+    const payload = buildMetadataPayload(totalChunks, totalFileSize, chunkSize, fileName, fileHash);
     return buildChunkOFDMFrame(payload, modName, rep, true);
 }
 
@@ -765,75 +2249,414 @@ function buildDataChunkFrame(chunkData, seqNum, modName, rep) {
     return buildChunkOFDMFrame(payload, modName, rep, false);
 }
 
+// XORs data into acc (acc at least as long as data)
+function xorInto(acc, data) {
+    for (let i = 0; i < data.length; i++) acc[i] ^= data[i];
+    return acc;
+}
+
+function buildParityPayload(firstSeq, count, parity) {
+    // [0xFD:1][firstSeq:4][count:1][parityLen:2][parity:N][CRC-32:4]
+    const buf = new Uint8Array(1 + 4 + 1 + 2 + parity.length + 4);
+    let off = 0;
+    buf[off++] = FRAME_PARITY;
+    buf[off++] = (firstSeq >> 24) & 0xFF;
+    buf[off++] = (firstSeq >> 16) & 0xFF;
+    buf[off++] = (firstSeq >> 8) & 0xFF;
+    buf[off++] = firstSeq & 0xFF;
+    buf[off++] = count;
+    buf[off++] = (parity.length >> 8) & 0xFF;
+    buf[off++] = parity.length & 0xFF;
+    buf.set(parity, off); off += parity.length;
+    const checksum = crc32(buf.subarray(0, off));
+    buf[off++] = (checksum >> 24) & 0xFF;
+    buf[off++] = (checksum >> 16) & 0xFF;
+    buf[off++] = (checksum >> 8) & 0xFF;
+    buf[off++] = checksum & 0xFF;
+    return buf;
+}
+
+// parity: XOR of the chunks firstSeq .. firstSeq+count-1
+function buildParityFrame(firstSeq, count, parity, modName, rep) {
+    return buildChunkOFDMFrame(buildParityPayload(firstSeq, count, parity), modName, rep, false);
+}
+
+// Beacon: a short frame a node repeats while idle, always BPSK with
+// BEACON_REPETITION-fold repetition and the header's beacon flag set.
+const BEACON_REPETITION = 7;
+const BEACON_PAD = 4;
+const BEACON_PAYLOAD_LEN = 11;
+const BEACON_BITS = BEACON_PAYLOAD_LEN * 8 * BEACON_REPETITION + BEACON_PAD;
+
+// Modulation and repetition of a frame from its header (or anything that
+// carries the header's beacon flag, such as a demodulation result)
+function frameModulation(header, modName) {
+    return header.beacon ? 'BPSK' : modName;
+}
+
+function frameRepetition(header, repetition) {
+    return header.beacon ? BEACON_REPETITION : repetition;
+}
+
+function buildBeaconPayload(nodeId, counter) {
+    // [0xFC:1][nodeId:4][counter:2][CRC-32:4]
+    const buf = new Uint8Array(BEACON_PAYLOAD_LEN);
+    let off = 0;
+    buf[off++] = FRAME_BEACON;
+    buf[off++] = (nodeId >> 24) & 0xFF;
+    buf[off++] = (nodeId >> 16) & 0xFF;
+    buf[off++] = (nodeId >> 8) & 0xFF;
+    buf[off++] = nodeId & 0xFF;
+    buf[off++] = (counter >> 8) & 0xFF;
+    buf[off++] = counter & 0xFF;
+    const checksum = crc32(buf.subarray(0, off));
+    buf[off++] = (checksum >> 24) & 0xFF;
+    buf[off++] = (checksum >> 16) & 0xFF;
+    buf[off++] = (checksum >> 8) & 0xFF;
+    buf[off++] = checksum & 0xFF;
+    return buf;
+}
+
+// nodeId: 32-bit; counter: 16-bit, wraps
+function buildBeaconFrame(nodeId, counter) {
+    const bits = repeatBits(bytesToBits(buildBeaconPayload(nodeId, counter & 0xFFFF)), BEACON_REPETITION);
+    for (let i = 0; i < BEACON_PAD; i++) bits.push(0);
+    return buildFrameSignal(bits, 'BPSK', false, true);
+}
+
+// Message: a short non-file payload in one frame tagged with a content type,
+// handed over as { data, contentType }. At most MAX_MESSAGE_BYTES with the type.
+const MESSAGE_OVERHEAD = 8; // frame type, type length, data length, CRC
+const MAX_MESSAGE_BYTES = MAX_FRAME_PAYLOAD - MESSAGE_OVERHEAD;
+const MAX_CONTENT_TYPE_LEN = 255;
+
+function buildMessagePayload(data, contentType) {
+    // [0xFB:1][typeLen:1][contentType:N][dataLen:2][data:M][CRC-32:4]
+    const typeBytes = new TextEncoder().encode(contentType);
+    const buf = new Uint8Array(1 + 1 + typeBytes.length + 2 + data.length + 4);
+    let off = 0;
+    buf[off++] = FRAME_MESSAGE;
+    buf[off++] = typeBytes.length;
+    buf.set(typeBytes, off); off += typeBytes.length;
+    buf[off++] = (data.length >> 8) & 0xFF;
+    buf[off++] = data.length & 0xFF;
+    buf.set(data, off); off += data.length;
+    const checksum = crc32(buf.subarray(0, off));
+    buf[off++] = (checksum >> 24) & 0xFF;
+    buf[off++] = (checksum >> 16) & 0xFF;
+    buf[off++] = (checksum >> 8) & 0xFF;
+    buf[off++] = checksum & 0xFF;
+    return buf;
+}
+
+// A standalone transmission, so it gets the lead-in of a first frame.
+// { error } for a message or content type too long for the frame.
+function buildMessageFrame(data, contentType, modName, rep) {
+    const typeLen = new TextEncoder().encode(contentType).length;
+    if (typeLen > MAX_CONTENT_TYPE_LEN) return { error: `Content type must be at most ${MAX_CONTENT_TYPE_LEN} bytes` };
+    if (data.length + typeLen > MAX_MESSAGE_BYTES) {
+        return { error: `A message and its content type carry at most ${MAX_MESSAGE_BYTES} bytes` };
+    }
+    return buildChunkOFDMFrame(buildMessagePayload(data, contentType), modName, rep, true);
+}
+
+// Length of chunk seq in a file of totalFileSize bytes
+function chunkLength(seq, chunkSize, totalFileSize) {
+    return Math.max(0, Math.min(chunkSize, totalFileSize - seq * chunkSize));
+}
+
+// The one missing chunk of a parity group: parity XOR every other chunk,
+// cut to the chunk's own length
+function recoverChunkFromParity(parity, otherChunks, length) {
+    const acc = Uint8Array.from(parity);
+    for (const c of otherChunks) xorInto(acc, c);
+    return acc.slice(0, length);
+}
+
 // --- Decode chunk frame (after preamble detection + CE) ---
 
+// setFrameDebug's hook gets fn({ stage, error, rawBytes, bytes, snrDb }) per
+// failed frame; rawBytes/bytes are before/after repetition voting, or null.
+let frameDebug = null;
+
+function setFrameDebug(fn) {
+    frameDebug = fn || null;
+}
+
+function reportFrameFailure(stage, error, rawBits, bytes, snrDb) {
+    if (!frameDebug) return;
+    frameDebug({
+        stage, error,
+        rawBytes: rawBits ? new Uint8Array(bitsToBytes(rawBits)) : null,
+        bytes: bytes ? new Uint8Array(bytes) : null,
+        snrDb: snrDb === undefined ? null : snrDb,
+    });
+}
+
 function decodeChunkFrame(frameSamples, modName, repetition) {
     repetition = repetition || 1;
     // frameSamples should start from preamble1
     // Structure: [preamble1][preamble2][CE][data symbols...]
     const ceStart = 2 * OFDM.SYMBOL_LEN;
     if (ceStart + OFDM.SYMBOL_LEN > frameSamples.length) {
-        return { error: 'Frame too short for CE' };
+        return { error: 'Frame too short for CE', reason: DECODE_FAIL.TRUNCATED };
     }
 
-    const ceSamples = frameSamples.slice(ceStart, ceStart + OFDM.SYMBOL_LEN);
-    const ce = generateChannelEstSymbol();
-    const [chRe, chIm] = estimateChannel(ceSamples, ce.knownRe, ce.knownIm);
-
     const dataStart = ceStart + OFDM.SYMBOL_LEN;
     if (dataStart >= frameSamples.length) {
-        return { error: 'No data after CE' };
+        return { error: 'No data after CE', reason: DECODE_FAIL.TRUNCATED };
     }
 
-    const dataSamples = frameSamples.slice(dataStart);
-    let bits = demodulateOFDM(dataSamples, modName, chRe, chIm);
+    const { demod, chRe, chIm } = demodulateAfterCE(frameSamples, ceStart, modName);
+    if (demod.error) {
+        reportFrameFailure(demod.reason, demod.error, demod.bits, null);
+        return { error: demod.error, reason: demod.reason };
+    }
+    let bits = demod.bits;
+    repetition = frameRepetition(demod, repetition);
     if (repetition > 1) bits = majorityVote(bits, repetition);
-    const bytes = bitsToBytes(bits);
 
-    if (bytes.length < 6) return { error: 'Decoded data too short' };
+    const bytes = bitsToBytes(bits);
+    const result = parseChunkFrameBytes(bytes);
+    // A damaged copy keeps its soft symbols so a later copy can be combined with it
+    if (!result.crcValid) {
+        result.soft = demod.soft;
+        reportFrameFailure(result.reason || DECODE_FAIL.CRC, result.error || 'CRC mismatch', demod.bits, bytes, demod.snrDb);
+    }
+    if (result.error) return result;
+    return { ...result, snrDb: demod.snrDb, evm: demod.evm, suggestedMask: demod.suggestedMask, ...reverbCheck(chRe, chIm) };
+}
 
+function parseChunkFrameBytes(bytes) {
+    if (bytes.length < 6) return { error: 'Decoded data too short', reason: DECODE_FAIL.TRUNCATED };
     const frameType = bytes[0];
-    if (frameType === FRAME_META) {
-        return parseMetadataResult(bytes);
-    } else if (frameType === FRAME_DATA) {
-        return parseDataChunkResult(bytes);
-    } else {
-        return { error: `Unknown frame type: 0x${frameType.toString(16)}`, frameType };
+    if (frameType === FRAME_META) return parseMetadataResult(bytes);
+    if (frameType === FRAME_DATA) return parseDataChunkResult(bytes);
+    if (frameType === FRAME_PARITY) return parseParityResult(bytes);
+    if (frameType === FRAME_BEACON) return parseBeaconResult(bytes);
+    if (frameType === FRAME_MESSAGE) return parseMessageResult(bytes);
+    return { error: `Unknown frame type: 0x${frameType.toString(16)}`, reason: DECODE_FAIL.FRAME_TYPE, frameType };
+}
+
+// --- Soft combining of repeated frames (Chase combining) ---
+// A failed copy's equalized data symbols are kept and averaged with a later
+// copy of the same shape, weighted by per-subcarrier SNR, before demapping.
+function newSoftSymbols(n, header, modName) {
+    return {
+        re: new Float32Array(n), im: new Float32Array(n), w: new Float32Array(n), count: 0,
+        totalBits: header.totalBits, mask: header.mask, beacon: header.beacon, modName, copies: 1,
+    };
+}
+
+function softSymbolsMatch(a, b) {
+    return a.modName === b.modName && a.totalBits === b.totalBits && a.mask === b.mask && a.beacon === b.beacon &&
+        a.re.length === b.re.length;
+}
+
+function mergeSoftSymbols(a, b) {
+    const out = newSoftSymbols(a.re.length, a, a.modName);
+    out.count = Math.max(a.count, b.count);
+    out.copies = a.copies + b.copies;
+    for (let i = 0; i < out.count; i++) {
+        const wa = i < a.count ? a.w[i] : 0, wb = i < b.count ? b.w[i] : 0;
+        const w = wa + wb;
+        out.w[i] = w;
+        if (w > 0) {
+            out.re[i] = (a.re[i] * wa + b.re[i] * wb) / w;
+            out.im[i] = (a.im[i] * wa + b.im[i] * wb) / w;
+        }
+    }
+    return out;
+}
+
+function decodeSoftSymbols(soft, repetition) {
+    const c = initConstellation(soft.modName);
+    let bits = [];
+    for (let i = 0; i < soft.count; i++) {
+        const idx = constellationDemapIndex(c, soft.re[i], soft.im[i]);
+        for (let b = c.bps - 1; b >= 0; b--) bits.push((idx >> b) & 1);
+    }
+    if (bits.length < soft.totalBits) return { error: 'Frame truncated', reason: DECODE_FAIL.TRUNCATED };
+    bits.length = soft.totalBits;
+    repetition = frameRepetition(soft, repetition);
+    if (repetition > 1) bits = majorityVote(bits, repetition);
+    return parseChunkFrameBytes(bitsToBytes(bits));
+}
+
+// Holds failed copies (at most maxCopies, oldest dropped first). The
+// sequence number of a damaged copy is only a hint, so a new failed copy is
+// tried against every stored copy of the same shape, the hinted one first.
+class SoftCombiner {
+    constructor(repetition = 1, maxCopies = 32) {
+        this.repetition = repetition;
+        this.maxCopies = maxCopies;
+        this.copies = []; // { soft, seqHint }
+    }
+
+    // Takes a failed decodeChunkFrame result. Returns the combined decode
+    // (with combinedCopies) once it passes CRC, else null and keeps the copy.
+    combine(result) {
+        const soft = result.soft;
+        if (!soft) return null;
+        const seqHint = result.frameType === FRAME_DATA ? result.seqNum : result.frameType;
+        const order = this.copies
+            .filter(e => softSymbolsMatch(e.soft, soft))
+            .sort((a, b) => (b.seqHint === seqHint) - (a.seqHint === seqHint));
+        let hinted = null;
+        for (const entry of order) {
+            const merged = mergeSoftSymbols(entry.soft, soft);
+            const decoded = decodeSoftSymbols(merged, this.repetition);
+            if (!decoded.error && decoded.crcValid) {
+                this.copies.splice(this.copies.indexOf(entry), 1);
+                return { ...decoded, combinedCopies: merged.copies };
+            }
+            if (!hinted && entry.seqHint === seqHint) hinted = { entry, merged };
+        }
+        // Still undecodable: accumulate into the hinted copy for the next try
+        if (hinted) { hinted.entry.soft = hinted.merged; return null; }
+        this.copies.push({ soft, seqHint });
+        if (this.copies.length > this.maxCopies) this.copies.shift();
+        return null;
+    }
+}
+
+// --- Decode every chunk frame in a recording ---
+// One decodeChunkFrame result per frame, with preambleIdx; damaged repeats
+// come back soft-combined, and a frame cut off at the end as { incomplete }.
+function decodeFrames(samples, modName, repetition) {
+    const signal = preprocessSignal(samples);
+    // The overlap holds a whole preamble, every repeat included
+    const win = (7 + OFDM.preambleRepeats) * OFDM.SYMBOL_LEN, hop = win - (1 + OFDM.preambleRepeats) * OFDM.SYMBOL_LEN;
+    const frames = [];
+    const combiner = new SoftCombiner(repetition);
+
+    let pos = 0;
+    while (pos + 3 * OFDM.SYMBOL_LEN < signal.length) {
+        // Search only: a gain that moves inside a frame would upset 16-QAM
+        const coarse = detectPreamble(applyAGC(signal.subarray(pos, pos + win)));
+        if (coarse < 0) { pos += hop; continue; }
+        const { index, metric } = refinePreambleCrossCorr(signal, pos + coarse);
+        let header = metric < 0.1 ? { error: 'Low correlation' }
+            : readFrameHeader(signal.subarray(index + 2 * OFDM.SYMBOL_LEN));
+        if (!header.error && chunkFrameTooLong(header, repetition)) {
+            header = { error: 'Frame length out of range', reason: DECODE_FAIL.HEADER };
+        }
+        if (header.error) {
+            if (header.reason === DECODE_FAIL.TRUNCATED) {
+                frames.push({ incomplete: true, error: header.error, reason: header.reason, preambleIdx: index });
+                break;
+            }
+            const last = frames[frames.length - 1];
+            if (header.reason === DECODE_FAIL.VERSION && !(last && last.preambleIdx === index)) {
+                frames.push({ error: header.error, reason: header.reason, version: header.version, preambleIdx: index });
+            }
+            pos = pos + coarse + OFDM.SYMBOL_LEN;
+            continue;
+        }
+
+        // index is the last preamble1 copy
+        const frameLen = frameSamplesForBits(header.totalBits, frameModulation(header, modName), header.mask) - extraPreambleSamples();
+        if (index + frameLen > signal.length) {
+            frames.push({ incomplete: true, error: 'Frame truncated', reason: DECODE_FAIL.TRUNCATED, preambleIdx: index });
+            break;
+        }
+        const result = decodeChunkFrame(signal.subarray(index, index + frameLen + OFDM.CP_LEN), modName, repetition);
+        const combined = result.soft ? combiner.combine(result) : null;
+        frames.push({ ...(combined || result), preambleIdx: index });
+        pos = index + frameLen;
+    }
+    return frames;
+}
+
+// Builds the file from decodeFrames output: { data, fileName, totalChunks,
+// missing } (missing chunks zero-filled), or { error } without metadata.
+// With a file hash in the metadata, a complete file also gets hashAlg and
+// hashValid (a mismatch is an error too).
+function assembleChunkFrames(frames) {
+    const meta = frames.find(f => f.frameType === FRAME_META && f.crcValid);
+    if (!meta) {
+        const newer = frames.find(f => f.reason === DECODE_FAIL.VERSION);
+        return { error: newer ? newer.error : 'Metadata frame not found' };
     }
+    const data = new Uint8Array(meta.totalFileSize);
+    const have = new Set();
+    for (const f of frames) {
+        if (f.frameType !== FRAME_DATA || !f.crcValid || f.seqNum >= meta.totalChunks) continue;
+        if (f.dataLen !== chunkLength(f.seqNum, meta.chunkSize, meta.totalFileSize)) continue; // from another transfer
+        const off = f.seqNum * meta.chunkSize;
+        data.set(f.data, off);
+        have.add(f.seqNum);
+    }
+    // A parity frame fills in its group's only missing chunk
+    for (const f of frames) {
+        if (f.frameType !== FRAME_PARITY || !f.crcValid) continue;
+        const group = [];
+        for (let seq = f.firstSeq; seq < Math.min(f.firstSeq + f.count, meta.totalChunks); seq++) group.push(seq);
+        const lost = group.filter(seq => !have.has(seq));
+        if (lost.length !== 1) continue;
+        const chunk = seq => data.subarray(seq * meta.chunkSize, seq * meta.chunkSize + chunkLength(seq, meta.chunkSize, meta.totalFileSize));
+        const others = group.filter(seq => seq !== lost[0]).map(chunk);
+        const len = chunkLength(lost[0], meta.chunkSize, meta.totalFileSize);
+        data.set(recoverChunkFromParity(f.parity, others, len), lost[0] * meta.chunkSize);
+        have.add(lost[0]);
+    }
+    const missing = [];
+    for (let seq = 0; seq < meta.totalChunks; seq++) if (!have.has(seq)) missing.push(seq);
+    const result = { data, fileName: meta.fileName, totalChunks: meta.totalChunks, missing };
+    if (missing.length) result.error = `Missing ${missing.length}/${meta.totalChunks} chunks`;
+    else if (meta.fileHash) {
+        result.hashAlg = meta.fileHash.alg;
+        result.hashValid = hashesEqual(hashBytes(meta.fileHash.alg, data), meta.fileHash.digest);
+        if (!result.hashValid) result.error = `${FILE_HASH_NAMES[meta.fileHash.alg]} mismatch`;
+    }
+    return result;
 }
 
 function parseMetadataResult(bytes) {
     // [0xFE:1][totalChunks:4][totalFileSize:4][chunkSize:2][fileNameLen:1][fileName:N][CRC-32:4]
-    if (bytes.length < 16) return { error: 'Metadata frame too short' };
+    if (bytes.length < 16) return { error: 'Metadata frame too short', reason: DECODE_FAIL.TRUNCATED };
     let off = 1;
     const totalChunks = (bytes[off] << 24) | (bytes[off+1] << 16) | (bytes[off+2] << 8) | bytes[off+3]; off += 4;
     const totalFileSize = (bytes[off] << 24) | (bytes[off+1] << 16) | (bytes[off+2] << 8) | bytes[off+3]; off += 4;
     const chunkSize = (bytes[off] << 8) | bytes[off+1]; off += 2;
     const nameLen = bytes[off++];
-    if (off + nameLen + 4 > bytes.length) return { error: 'Metadata frame truncated' };
+    if (off + nameLen + 4 > bytes.length) return { error: 'Metadata frame truncated', reason: DECODE_FAIL.TRUNCATED };
     let fileName = '';
-    try { fileName = new TextDecoder().decode(bytes.slice(off, off + nameLen)); } catch(e) {}
+    try { fileName = sanitizeFileName(new TextDecoder().decode(bytes.slice(off, off + nameLen))); } catch(e) {}
     off += nameLen;
 
     // Verify CRC
     const expectedCRC = ((bytes[off] << 24) | (bytes[off+1] << 16) | (bytes[off+2] << 8) | bytes[off+3]) >>> 0;
     const actualCRC = crc32(bytes.subarray(0, off));
+    off += 4;
+
+    // Optional whole-file hash (absent from older senders)
+    let fileHash = null, extValid = true;
+    const hashLen = FILE_HASH_LEN[bytes[off]];
+    if (hashLen && off + 1 + hashLen + 4 <= bytes.length) {
+        const alg = bytes[off++];
+        const digest = bytes.slice(off, off + hashLen);
+        off += hashLen;
+        const extCRC = ((bytes[off] << 24) | (bytes[off+1] << 16) | (bytes[off+2] << 8) | bytes[off+3]) >>> 0;
+        extValid = extCRC === crc32(bytes.subarray(0, off));
+        fileHash = { alg, digest };
+    }
 
     return {
         frameType: FRAME_META,
-        totalChunks, totalFileSize, chunkSize, fileName,
-        crcValid: expectedCRC === actualCRC,
+        totalChunks, totalFileSize, chunkSize, fileName, fileHash,
+        crcValid: expectedCRC === actualCRC && extValid,
         expectedCRC, actualCRC,
     };
 }
 
 function parseDataChunkResult(bytes) {
     // [0xFF:1][seqNum:4][chunkDataLen:2][data:N][CRC-32:4]
-    if (bytes.length < 11) return { error: 'Data chunk frame too short' };
+    if (bytes.length < 11) return { error: 'Data chunk frame too short', reason: DECODE_FAIL.TRUNCATED };
     let off = 1;
     const seqNum = (bytes[off] << 24) | (bytes[off+1] << 16) | (bytes[off+2] << 8) | bytes[off+3]; off += 4;
     const dataLen = (bytes[off] << 8) | bytes[off+1]; off += 2;
-    if (off + dataLen + 4 > bytes.length) return { error: 'Data chunk truncated' };
+    if (off + dataLen + 4 > bytes.length) return { error: 'Data chunk truncated', reason: DECODE_FAIL.TRUNCATED };
     const data = bytes.slice(off, off + dataLen);
     off += dataLen;
 
@@ -848,6 +2671,89 @@ function parseDataChunkResult(bytes) {
     };
 }
 
+function parseParityResult(bytes) {
+    // [0xFD:1][firstSeq:4][count:1][parityLen:2][parity:N][CRC-32:4]
+    if (bytes.length < 12) return { error: 'Parity frame too short', reason: DECODE_FAIL.TRUNCATED };
+    let off = 1;
+    const firstSeq = (bytes[off] << 24) | (bytes[off+1] << 16) | (bytes[off+2] << 8) | bytes[off+3]; off += 4;
+    const count = bytes[off++];
+    const parityLen = (bytes[off] << 8) | bytes[off+1]; off += 2;
+    if (off + parityLen + 4 > bytes.length) return { error: 'Parity frame truncated', reason: DECODE_FAIL.TRUNCATED };
+    const parity = bytes.slice(off, off + parityLen);
+    off += parityLen;
+
+    const expectedCRC = ((bytes[off] << 24) | (bytes[off+1] << 16) | (bytes[off+2] << 8) | bytes[off+3]) >>> 0;
+    const actualCRC = crc32(bytes.subarray(0, off));
+
+    return {
+        frameType: FRAME_PARITY,
+        firstSeq, count, parity,
+        crcValid: expectedCRC === actualCRC,
+        expectedCRC, actualCRC,
+    };
+}
+
+function parseBeaconResult(bytes) {
+    // [0xFC:1][nodeId:4][counter:2][CRC-32:4]
+    if (bytes.length < BEACON_PAYLOAD_LEN) return { error: 'Beacon frame too short', reason: DECODE_FAIL.TRUNCATED };
+    const nodeId = ((bytes[1] << 24) | (bytes[2] << 16) | (bytes[3] << 8) | bytes[4]) >>> 0;
+    const counter = (bytes[5] << 8) | bytes[6];
+    const expectedCRC = ((bytes[7] << 24) | (bytes[8] << 16) | (bytes[9] << 8) | bytes[10]) >>> 0;
+    const actualCRC = crc32(bytes.subarray(0, 7));
+
+    return {
+        frameType: FRAME_BEACON,
+        nodeId, counter,
+        crcValid: expectedCRC === actualCRC,
+        expectedCRC, actualCRC,
+    };
+}
+
+function parseMessageResult(bytes) {
+    // [0xFB:1][typeLen:1][contentType:N][dataLen:2][data:M][CRC-32:4]
+    let off = 1;
+    const typeLen = bytes[off++];
+    if (off + typeLen + 2 + 4 > bytes.length) return { error: 'Message frame truncated', reason: DECODE_FAIL.TRUNCATED };
+    let contentType = '';
+    try { contentType = new TextDecoder().decode(bytes.slice(off, off + typeLen)); } catch(e) {}
+    off += typeLen;
+    const dataLen = (bytes[off] << 8) | bytes[off+1]; off += 2;
+    if (off + dataLen + 4 > bytes.length) return { error: 'Message frame truncated', reason: DECODE_FAIL.TRUNCATED };
+    const data = bytes.slice(off, off + dataLen);
+    off += dataLen;
+
+    const expectedCRC = ((bytes[off] << 24) | (bytes[off+1] << 16) | (bytes[off+2] << 8) | bytes[off+3]) >>> 0;
+    const actualCRC = crc32(bytes.subarray(0, off));
+
+    return {
+        frameType: FRAME_MESSAGE,
+        contentType, data, dataLen,
+        crcValid: expectedCRC === actualCRC,
+        expectedCRC, actualCRC,
+    };
+}
+
+// File names arrive over the air, so only the last path component is kept
+// (either separator), control characters are dropped and "." / ".." are
+// refused. '' means no usable name; callers fall back to a default.
+function sanitizeFileName(name) {
+    const base = String(name).split(/[\\/]/).pop().replace(/[\u0000-\u001f\u007f]/g, '').trim();
+    return base === '.' || base === '..' ? '' : base;
+}
+
+// name, or "name (1).ext", "name (2).ext", ... whichever taken() says is
+// free first, so a received file never replaces an earlier one
+function uniqueFileName(name, taken) {
+    if (!taken(name)) return name;
+    const dot = name.lastIndexOf('.');
+    const base = dot > 0 ? name.slice(0, dot) : name;
+    const ext = dot > 0 ? name.slice(dot) : '';
+    for (let n = 1; ; n++) {
+        const candidate = `${base} (${n})${ext}`;
+        if (!taken(candidate)) return candidate;
+    }
+}
+
 // --- Parse helpers (for external use after raw byte extraction) ---
 
 function parseMetadataPayload(bytes) {
@@ -862,42 +2768,419 @@ function parseDataChunkPayload(bytes) {
 
 function estimateFrameSamples(payloadBytes, modName, repetition) {
     repetition = repetition || 1;
+    return frameSamplesForBits(payloadBytes * 8 * repetition, modName);
+}
+
+// Exact frame length (from the first preamble1 copy) for the coded bit
+// count and subcarrier mask in a header; modName is the frame's own
+// (frameModulation)
+function frameSamplesForBits(totalBits, modName, mask = OFDM.subMask) {
     const c = initConstellation(modName);
-    const numDataSubs = OFDM.numDataSubs();
-    const bitsPerSymbol = numDataSubs * c.bps;
-    const totalBits = payloadBytes * 8 * repetition;
+    const bitsPerSymbol = OFDM.dataSubcarriers(mask).length * c.bps;
     const numSymbols = Math.ceil(totalBits / bitsPerSymbol);
 
-    // preamble1 + preamble2 + CE + data symbols
-    const headerSymbols = 3; // pre1 + pre2 + CE
-    return (headerSymbols + numSymbols) * OFDM.SYMBOL_LEN;
+    // preamble1 copies + preamble2 + CE + frame header + data symbols (+ re-sync symbols)
+    const leadSymbols = OFDM.preambleRepeats + 2;
+    return (leadSymbols + OFDM.numHeaderSymbols() + numSymbols + OFDM.numSyncSymbols(numSymbols)) * OFDM.SYMBOL_LEN;
 }
 
 function estimateFrameSamplesWithSilence(payloadBytes, modName, repetition, isFirstFrame) {
     const coreSamples = estimateFrameSamples(payloadBytes, modName, repetition);
-    const isAcoustic = OFDM.CP_LEN >= 128;
-    const silencePre = isFirstFrame
-        ? Math.round(OFDM.SAMPLE_RATE * (isAcoustic ? 0.5 : 0.3))
-        : Math.round(OFDM.SAMPLE_RATE * 0.05);
-    const silencePost = Math.round(OFDM.SAMPLE_RATE * 0.02);
+    const silencePre = frameGuardSamples(isFirstFrame ? 'lead' : 'gap');
+    const silencePost = Math.round(OFDM.SAMPLE_RATE * CHUNK_FRAME_TAIL);
     return silencePre + coreSamples + silencePost;
 }
 
+// ============================================================
+// Resampling — Device Rate ↔ Modem Rate
+// ============================================================
+
+// Windowed-sinc (Hann) interpolation at fractional input position x. The
+// kernel is symmetric, so the resampler is linear-phase and the preamble
+// still correlates; cutoff < 1 low-passes when downsampling.
+function sincInterp(buf, x, cutoff, halfWidth) {
+    const j = Math.floor(x);
+    let v = 0;
+    for (let t = j - halfWidth + 1; t <= j + halfWidth; t++) {
+        if (t < 0 || t >= buf.length) continue;
+        const u = x - t;
+        const a = Math.PI * cutoff * u;
+        const sinc = a === 0 ? 1 : Math.sin(a) / a;
+        const w = 0.5 + 0.5 * Math.cos(Math.PI * u / halfWidth);
+        v += buf[t] * cutoff * sinc * w;
+    }
+    return v;
+}
+
+// Block-by-block resampler for live audio; keeps enough history between
+// blocks that the output is identical to resampling the whole stream.
+class StreamResampler {
+    constructor(inRate, outRate, halfWidth) {
+        this.step = inRate / outRate;
+        this.cutoff = Math.min(1, outRate / inRate);
+        this.halfWidth = halfWidth || 16;
+        this.buf = new Float32Array(this.halfWidth); // leading zeros as history
+        this.pos = this.halfWidth;                   // input position of next output
+    }
+
+    process(input) {
+        const buf = new Float32Array(this.buf.length + input.length);
+        buf.set(this.buf);
+        buf.set(input, this.buf.length);
+
+        const out = new Float32Array(Math.max(0, Math.ceil((buf.length - this.halfWidth - this.pos) / this.step)));
+        let n = 0;
+        while (this.pos + this.halfWidth < buf.length && n < out.length) {
+            out[n++] = sincInterp(buf, this.pos, this.cutoff, this.halfWidth);
+            this.pos += this.step;
+        }
+
+        const drop = Math.max(0, Math.floor(this.pos) - this.halfWidth);
+        this.buf = buf.slice(drop);
+        this.pos -= drop;
+        return n === out.length ? out : out.slice(0, n);
+    }
+}
+
+function resample(input, inRate, outRate) {
+    if (inRate === outRate) return input;
+    const r = new StreamResampler(inRate, outRate);
+    const head = r.process(input);
+    const tail = r.process(new Float32Array(Math.ceil(r.halfWidth * r.step) + 1));
+    const outLen = Math.round(input.length * outRate / inRate);
+    const out = new Float32Array(outLen);
+    out.set(head.subarray(0, outLen));
+    if (head.length < outLen) out.set(tail.subarray(0, outLen - head.length), head.length);
+    return out;
+}
+
+// ============================================================
+// Band-pass Filtering — Receive Path
+// ============================================================
+
+// Linear-phase FIR (Blackman-windowed sinc) passing the data band with a
+// BANDPASS_MARGIN-bin margin each side; its delay is removed.
+const BANDPASS_MARGIN = 3;
+
+function bandpassTaps(numTaps) {
+    const binHz = OFDM.SAMPLE_RATE / OFDM.FFT_SIZE;
+    const lo = Math.max(0, OFDM.SUB_START - BANDPASS_MARGIN) * binHz / OFDM.SAMPLE_RATE;
+    const hi = Math.min(OFDM.FFT_SIZE / 2, OFDM.SUB_END + BANDPASS_MARGIN) * binHz / OFDM.SAMPLE_RATE;
+    const mid = (numTaps - 1) / 2;
+    const taps = new Float64Array(numTaps);
+    for (let i = 0; i < numTaps; i++) {
+        const m = i - mid;
+        const ideal = m === 0 ? 2 * (hi - lo)
+            : (Math.sin(2 * Math.PI * hi * m) - Math.sin(2 * Math.PI * lo * m)) / (Math.PI * m);
+        const w = 0.42 - 0.5 * Math.cos(2 * Math.PI * i / (numTaps - 1)) + 0.08 * Math.cos(4 * Math.PI * i / (numTaps - 1));
+        taps[i] = ideal * w;
+    }
+    return taps;
+}
+
+// Block-by-block band-pass for live audio (FFT overlap-save). Output has the
+// same length as the input and lags it by `delay` samples.
+class BandpassFilter {
+    constructor(numTaps) {
+        this.numTaps = numTaps || 2 * OFDM.FFT_SIZE - 1;
+        this.delay = (this.numTaps - 1) / 2;
+        this.n = 4 * OFDM.FFT_SIZE;
+        while (this.n < 2 * this.numTaps) this.n *= 2;
+        const padded = new Float64Array(this.n);
+        padded.set(bandpassTaps(this.numTaps));
+        [this.hRe, this.hIm] = rfft(padded);
+        this.hist = new Float32Array(this.numTaps - 1);
+        this.seg = new Float64Array(this.n);
+    }
+
+    process(input) {
+        const keep = this.numTaps - 1, step = this.n - keep;
+        const buf = new Float32Array(keep + input.length);
+        buf.set(this.hist);
+        buf.set(input, keep);
+        const out = new Float32Array(input.length);
+        for (let o = 0; o < input.length; o += step) {
+            const len = Math.min(step, input.length - o);
+            this.seg.fill(0);
+            this.seg.set(buf.subarray(o, o + keep + len));
+            const [re, im] = rfft(this.seg);
+            for (let k = 0; k <= this.n / 2; k++) {
+                const r = re[k] * this.hRe[k] - im[k] * this.hIm[k];
+                im[k] = re[k] * this.hIm[k] + im[k] * this.hRe[k];
+                re[k] = r;
+            }
+            const y = irfft(re, im);
+            for (let i = 0; i < len; i++) out[o + i] = y[keep + i];
+        }
+        this.hist = buf.slice(buf.length - keep);
+        return out;
+    }
+}
+
+// Zero-phase band-pass of a whole recording (filter delay removed). The
+// input is zero-padded by the delay so its last samples come out too, even
+// when the whole recording is shorter than the delay.
+function bandpass(signal) {
+    const f = new BandpassFilter();
+    const padded = new Float32Array(signal.length + f.delay);
+    padded.set(signal);
+    return f.process(padded).slice(f.delay);
+}
+
+// ============================================================
+// Diversity Combining — Two Mics / Stereo Recordings
+// ============================================================
+
+// Maximal-ratio combining of sample-aligned channels of one transmission,
+// each modelled as a·s + n and weighted by a/σ².
+function combineMRC(channels, blockSize = 1024) {
+    if (channels.length === 1) return channels[0];
+    const n = Math.min(...channels.map(c => c.length));
+
+    const stats = channels.map((x) => {
+        const blocks = [];
+        let total = 0;
+        for (let start = 0; start < n; start += blockSize) {
+            const end = Math.min(n, start + blockSize);
+            let e = 0;
+            for (let i = start; i < end; i++) e += x[i] * x[i];
+            blocks.push(e / (end - start));
+            total += e;
+        }
+        blocks.sort((p, q) => p - q);
+        const noise = Math.max(blocks[Math.floor(blocks.length * 0.1)], 1e-12);
+        return { noise, amp: Math.sqrt(Math.max(total / n - noise, 0)) };
+    });
+
+    let ref = 0;
+    stats.forEach((st, c) => { if (st.amp > stats[ref].amp) ref = c; });
+    const weights = channels.map((x, c) => {
+        let cross = 0;
+        for (let i = 0; i < n; i++) cross += x[i] * channels[ref][i];
+        return Math.sign(cross) * stats[c].amp / stats[c].noise;
+    });
+    // Unit gain relative to the strongest channel
+    const gain = weights.reduce((sum, w, c) => sum + Math.abs(w) * stats[c].amp, 0) / (stats[ref].amp || 1);
+
+    const out = new Float32Array(n);
+    if (gain === 0) { out.set(channels[ref].subarray(0, n)); return out; }
+    channels.forEach((x, c) => {
+        const w = weights[c] / gain;
+        for (let i = 0; i < n; i++) out[i] += w * x[i];
+    });
+    return out;
+}
+
+// ============================================================
+// WAV I/O — Hardware-Free Send/Receive
+// ============================================================
+
+// Mono 16-bit PCM WAV from float samples in [-1, 1]
+function encodeWAV(signal, sampleRate) {
+    const out = new Uint8Array(44 + signal.length * 2);
+    out.set(wavHeader(signal.length, sampleRate));
+    out.set(encodePCM16(signal), 44);
+    return out;
+}
+
+// The 44-byte header alone, for a WAV written in parts (see encodePCM16)
+function wavHeader(numSamples, sampleRate) {
+    const dataLen = numSamples * 2;
+    const v = new DataView(new ArrayBuffer(44));
+    const str = (off, text) => { for (let i = 0; i < text.length; i++) v.setUint8(off + i, text.charCodeAt(i)); };
+
+    str(0, 'RIFF'); v.setUint32(4, 36 + dataLen, true); str(8, 'WAVE');
+    str(12, 'fmt '); v.setUint32(16, 16, true);
+    v.setUint16(20, 1, true);               // PCM
+    v.setUint16(22, 1, true);               // mono
+    v.setUint32(24, sampleRate, true);
+    v.setUint32(28, sampleRate * 2, true);  // byte rate
+    v.setUint16(32, 2, true);               // block align
+    v.setUint16(34, 16, true);              // bits per sample
+    str(36, 'data'); v.setUint32(40, dataLen, true);
+    return new Uint8Array(v.buffer);
+}
+
+// Little-endian 16-bit samples, clipped to [-1, 1]
+function encodePCM16(signal) {
+    const v = new DataView(new ArrayBuffer(signal.length * 2));
+    for (let i = 0; i < signal.length; i++) {
+        const x = Math.max(-1, Math.min(1, signal[i]));
+        v.setInt16(i * 2, Math.round(x * 32767), true);
+    }
+    return new Uint8Array(v.buffer);
+}
+
+// Parses 8/16/24/32-bit PCM or 32-bit float WAV. Multi-channel input is
+// merged with combineMRC. Returns { samples, sampleRate, numChannels } or { error }.
+function decodeWAV(bytes) {
+    const v = new DataView(bytes.buffer, bytes.byteOffset, bytes.byteLength);
+    const tag = (off) => String.fromCharCode(v.getUint8(off), v.getUint8(off + 1), v.getUint8(off + 2), v.getUint8(off + 3));
+    if (bytes.length < 12 || tag(0) !== 'RIFF' || tag(8) !== 'WAVE') return { error: 'Not a WAV file' };
+
+    let fmt = null, dataOff = -1, dataLen = 0;
+    for (let off = 12; off + 8 <= bytes.length;) {
+        const id = tag(off), size = v.getUint32(off + 4, true);
+        if (id === 'fmt ') {
+            fmt = {
+                format: v.getUint16(off + 8, true),
+                channels: v.getUint16(off + 10, true),
+                sampleRate: v.getUint32(off + 12, true),
+                bits: v.getUint16(off + 22, true),
+            };
+        } else if (id === 'data') {
+            dataOff = off + 8;
+            dataLen = Math.min(size, bytes.length - dataOff);
+            break;
+        }
+        off += 8 + size + (size & 1);
+    }
+    if (!fmt) return { error: 'WAV fmt chunk missing' };
+    if (dataOff < 0) return { error: 'WAV data chunk missing' };
+    if (fmt.channels === 0) return { error: 'WAV has no channels' };
+
+    const isFloat = fmt.format === 3;
+    if (!(fmt.format === 1 || (isFloat && fmt.bits === 32)) || ![8, 16, 24, 32].includes(fmt.bits)) {
+        return { error: `Unsupported WAV format (format ${fmt.format}, ${fmt.bits}-bit)` };
+    }
+
+    const bytesPerSample = fmt.bits / 8;
+    const frameSize = bytesPerSample * fmt.channels;
+    const numFrames = Math.floor(dataLen / frameSize);
+    const channels = Array.from({ length: fmt.channels }, () => new Float32Array(numFrames));
+    const read = (off) => {
+        if (isFloat) return v.getFloat32(off, true);
+        if (fmt.bits === 8) return (v.getUint8(off) - 128) / 128;
+        if (fmt.bits === 16) return v.getInt16(off, true) / 32768;
+        if (fmt.bits === 24) return ((v.getUint8(off + 2) << 24 | v.getUint8(off + 1) << 16 | v.getUint8(off) << 8) >> 8) / 8388608;
+        return v.getInt32(off, true) / 2147483648;
+    };
+    for (let i = 0; i < numFrames; i++) {
+        for (let ch = 0; ch < fmt.channels; ch++) channels[ch][i] = read(dataOff + i * frameSize + ch * bytesPerSample);
+    }
+    return { samples: combineMRC(channels), sampleRate: fmt.sampleRate, numChannels: fmt.channels };
+}
+
 // ============================================================
 // Pre-Test Functions — Audio Path Diagnostics
 // ============================================================
 
+// Input level check. 'clipping' means samples sat on the converter's rails
+// (the waveform is already destroyed); 'loud' only means little headroom.
+const INPUT_CLIP_LEVEL = 0.999;
+const INPUT_CLIP_MIN_SAMPLES = 3; // one stray sample at full scale isn't clipping
+const INPUT_LOUD_PEAK = 0.9;
+const INPUT_LOW_RMS = 0.005;
+
+// → { level: 'clipping' | 'loud' | 'low' | 'good', rms, rmsDb, peak, peakDb, clippedSamples }
+function classifyInputLevel(samples) {
+    let sumSq = 0, peak = 0, clippedSamples = 0;
+    for (let i = 0; i < samples.length; i++) {
+        const v = Math.abs(samples[i]);
+        sumSq += samples[i] * samples[i];
+        if (v > peak) peak = v;
+        if (v >= INPUT_CLIP_LEVEL) clippedSamples++;
+    }
+    const rms = samples.length ? Math.sqrt(sumSq / samples.length) : 0;
+    let level = 'good';
+    if (clippedSamples >= INPUT_CLIP_MIN_SAMPLES) level = 'clipping';
+    else if (peak > INPUT_LOUD_PEAK) level = 'loud';
+    else if (rms < INPUT_LOW_RMS) level = 'low';
+    return {
+        level, rms, peak, clippedSamples,
+        rmsDb: rms > 0 ? 20 * Math.log10(rms) : -Infinity,
+        peakDb: peak > 0 ? 20 * Math.log10(peak) : -Infinity,
+    };
+}
+
+// Acoustic feedback: a howl is one frequency (±1 bin) that stays tonal for
+// FEEDBACK_MIN_SECONDS and rises FEEDBACK_RISE_DB, which a steady tone never does.
+const FEEDBACK_FFT_SIZE = 2048;
+const FEEDBACK_TONAL_DB = 20;
+const FEEDBACK_MIN_SECONDS = 1;
+const FEEDBACK_RISE_DB = 10;
+const FEEDBACK_MIN_DBFS = -30;
+
+class FeedbackDetector {
+    constructor(sampleRate = OFDM.SAMPLE_RATE) {
+        this.sampleRate = sampleRate;
+        this.buf = new Float64Array(FEEDBACK_FFT_SIZE);
+        this.fill = 0;
+        this.run = null;  // { bin, windows, refDb, maxDb } of the current tonal stretch
+        this.howl = null; // { freq, levelDb } while howling
+    }
+
+    // → true when this block started or ended a howl (see this.howl)
+    process(samples) {
+        const before = this.howl;
+        for (let i = 0; i < samples.length; i++) {
+            this.buf[this.fill++] = samples[i];
+            if (this.fill === FEEDBACK_FFT_SIZE) {
+                this._analyze();
+                this.fill = 0;
+            }
+        }
+        return !before !== !this.howl;
+    }
+
+    _analyze() {
+        const n = FEEDBACK_FFT_SIZE;
+        const x = new Float64Array(n);
+        for (let i = 0; i < n; i++) x[i] = this.buf[i] * (0.5 - 0.5 * Math.cos(2 * Math.PI * i / n));
+        const [re, im] = rfft(x);
+        const power = new Float64Array(n / 2 - 1);
+        let peak = 0, bin = -1;
+        for (let k = 1; k < n / 2; k++) {
+            const p = re[k] * re[k] + im[k] * im[k];
+            power[k - 1] = p;
+            if (p > peak) { peak = p; bin = k; }
+        }
+        const median = power.sort()[power.length >> 1];
+        if (peak <= 0 || peak < median * Math.pow(10, FEEDBACK_TONAL_DB / 10)) {
+            this.run = null;
+            this.howl = null;
+            return;
+        }
+
+        // A Hann-windowed sine of amplitude A peaks at A·n/4
+        const levelDb = 20 * Math.log10(4 * Math.sqrt(peak) / n);
+        const run = this.run;
+        if (!run || Math.abs(bin - run.bin) > 1) {
+            this.run = { bin, windows: 1, refDb: null, maxDb: -Infinity };
+            this.howl = null;
+            return;
+        }
+        run.bin = bin;
+        run.windows++;
+        if (run.refDb === null) run.refDb = levelDb;
+        run.maxDb = Math.max(run.maxDb, levelDb);
+        if (this.howl) { this.howl.levelDb = levelDb; return; }
+        if (run.windows * n >= FEEDBACK_MIN_SECONDS * this.sampleRate &&
+            run.maxDb - run.refDb >= FEEDBACK_RISE_DB && levelDb >= FEEDBACK_MIN_DBFS) {
+            this.howl = { freq: bin * this.sampleRate / n, levelDb };
+        }
+    }
+}
+
+// Linear chirp from startFreq to endFreq
 function generateSweepTone(startFreq, endFreq, duration, sampleRate) {
+    return generateTone(duration, sampleRate,
+        t => 2 * Math.PI * (startFreq * t + (endFreq - startFreq) * t * t / (2 * duration)));
+}
+
+// Steady sine, e.g. for setting levels by ear or on a meter
+function generateCalibrationTone(freq, duration, sampleRate = OFDM.SAMPLE_RATE) {
+    return generateTone(duration, sampleRate, t => 2 * Math.PI * freq * t);
+}
+
+// phaseAt(t) → phase in radians; 50 ms fade in/out against clicks
+function generateTone(duration, sampleRate, phaseAt) {
     const numSamples = Math.round(duration * sampleRate);
     const signal = new Float32Array(numSamples);
-    const fadeLen = Math.round(0.05 * sampleRate); // 50ms fade
+    const fadeLen = Math.min(Math.round(0.05 * sampleRate), numSamples >> 1);
 
     for (let i = 0; i < numSamples; i++) {
-        const t = i / sampleRate;
-        // Linear frequency sweep
-        const freq = startFreq + (endFreq - startFreq) * (t / duration);
-        const phase = 2 * Math.PI * (startFreq * t + (endFreq - startFreq) * t * t / (2 * duration));
-        let sample = 0.8 * Math.sin(phase);
+        let sample = OFDM.outputAmplitude * Math.sin(phaseAt(i / sampleRate));
 
         // Fade-in/out envelope
         if (i < fadeLen) {
@@ -944,35 +3227,32 @@ function generateTestSignal(modName, repetition) {
     const { samples } = modulateOFDM(bits, modName);
 
     // Build: silence + preamble + CE + data + silence
-    const pre1 = generatePreambleSymbol1();
-    const pre2 = generatePreambleSymbol2();
+    const preamble = generatePreambleTrain();
     const ce = generateChannelEstSymbol();
 
-    const isAcoustic = OFDM.CP_LEN >= 128;
-    const silencePre = new Float32Array(OFDM.SAMPLE_RATE * (isAcoustic ? 0.5 : 0.3));
-    const silencePost = new Float32Array(OFDM.SAMPLE_RATE * (isAcoustic ? 0.5 : 0.2));
+    const silencePre = new Float32Array(frameGuardSamples('lead'));
+    const silencePost = new Float32Array(frameGuardSamples('trail'));
 
-    let totalLen = silencePre.length + pre1.length + pre2.length + ce.samples.length + silencePost.length;
+    let totalLen = silencePre.length + preamble.length + ce.samples.length + silencePost.length;
     for (const s of samples) totalLen += s.length;
 
     const signal = new Float32Array(totalLen);
     let off = 0;
     signal.set(silencePre, off); off += silencePre.length;
-    signal.set(pre1, off); off += pre1.length;
-    signal.set(pre2, off); off += pre2.length;
+    signal.set(preamble, off); off += preamble.length;
     signal.set(ce.samples, off); off += ce.samples.length;
     for (const s of samples) { signal.set(s, off); off += s.length; }
     signal.set(silencePost, off);
 
-    // Normalize
-    let mx = 0;
-    for (let i = 0; i < signal.length; i++) mx = Math.max(mx, Math.abs(signal[i]));
-    if (mx > 0) { const s = 0.8 / mx; for (let i = 0; i < signal.length; i++) signal[i] *= s; }
+    scaleToOutputAmplitude(signal);
 
     return { signal, testData };
 }
 
-function analyzeLoopback(recorded, modName, repetition, testData) {
+// playStartIdx: where in recorded playback of the test signal started, if
+// known. The preamble is then the marker for the round trip: latencyMs is
+// how much later than that it was heard (null if it was not detected).
+function analyzeLoopback(recorded, modName, repetition, testData, playStartIdx) {
     // Preprocess
     const signal = preprocessSignal(recorded);
 
@@ -983,38 +3263,20 @@ function analyzeLoopback(recorded, modName, repetition, testData) {
         coarseIdx = detectPreambleCrossCorr(signal);
     }
     if (coarseIdx < 0) {
-        return { detected: false, correlation: 0, ber: 1, channelMagnitude: [], snrEstimate: 0, quality: 'poor' };
+        return { detected: false, correlation: 0, ber: 1, channelMagnitude: [], delaySpread: null, snrEstimate: 0, quality: 'poor', latencyMs: null };
     }
 
     // Fine-tune with cross-correlation
-    const pre1 = generatePreambleSymbol1();
-    let tEnergy = 0;
-    for (let i = 0; i < pre1.length; i++) tEnergy += pre1[i] * pre1[i];
-
-    const searchRadius = OFDM.CP_LEN * 3;
-    const fineStart = Math.max(0, coarseIdx - searchRadius);
-    const fineEnd = Math.min(signal.length - pre1.length, coarseIdx + searchRadius);
-
-    let bestMetric = -Infinity, startIdx = coarseIdx;
-    for (let d = fineStart; d <= fineEnd; d++) {
-        let corr = 0, sEnergy = 0;
-        for (let i = 0; i < pre1.length; i++) {
-            corr += signal[d + i] * pre1[i];
-            sEnergy += signal[d + i] * signal[d + i];
-        }
-        const denom = Math.sqrt(sEnergy * tEnergy);
-        if (denom > 0.001) {
-            const metric = corr / denom;
-            if (metric > bestMetric) { bestMetric = metric; startIdx = d; }
-        }
-    }
+    const { index: startIdx, metric: bestMetric } = refinePreambleCrossCorr(signal, coarseIdx);
 
     const correlation = Math.max(0, bestMetric);
+    const latencyMs = playStartIdx === undefined || playStartIdx === null || bestMetric < 0.1 ? null
+        : (startIdx - extraPreambleSamples() - frameGuardSamples('lead') - playStartIdx) / OFDM.SAMPLE_RATE * 1000;
 
     // Channel estimation
     const ceStart = startIdx + 2 * OFDM.SYMBOL_LEN;
     if (ceStart + OFDM.SYMBOL_LEN > signal.length) {
-        return { detected: true, correlation, ber: 1, channelMagnitude: [], snrEstimate: 0, quality: 'poor' };
+        return { detected: true, correlation, ber: 1, channelMagnitude: [], delaySpread: null, snrEstimate: 0, quality: 'poor', latencyMs };
     }
 
     const ceSamples = signal.slice(ceStart, ceStart + OFDM.SYMBOL_LEN);
@@ -1027,6 +3289,7 @@ function analyzeLoopback(recorded, modName, repetition, testData) {
         const mag = Math.sqrt(chRe[k] * chRe[k] + chIm[k] * chIm[k]);
         channelMagnitude.push(mag);
     }
+    const { delaySpread, reverbWarning } = reverbCheck(chRe, chIm);
 
     // SNR estimation from pilot subcarriers
     let snrSum = 0, snrCount = 0;
@@ -1047,7 +3310,7 @@ function analyzeLoopback(recorded, modName, repetition, testData) {
     let ber = 1;
     if (dataStart < signal.length) {
         const dataSamples = signal.slice(dataStart);
-        let bits = demodulateOFDM(dataSamples, modName, chRe, chIm);
+        let bits = demodulateOFDM(dataSamples, modName, chRe, chIm).bits || [];
         if (repetition > 1) bits = majorityVote(bits, repetition);
         const decoded = bitsToBytes(bits);
 
@@ -1078,5 +3341,10 @@ function analyzeLoopback(recorded, modName, repetition, testData) {
         quality = 'poor';
     }
 
-    return { detected: true, correlation, ber, channelMagnitude, snrEstimate, quality };
+    return { detected: true, correlation, ber, channelMagnitude, delaySpread, reverbWarning, snrEstimate, quality, latencyMs };
+}
+
+// Node (cli.js); in the browser the declarations above are plain globals
+if (typeof module !== 'undefined') {
+    module.exports = { Modem, getModemParams, CHUNK_THRESHOLD, encodeWAV, decodeWAV, resample, sanitizeFileName, uniqueFileName, registerConstellation, defineBandConfig, decodeFrames, assembleChunkFrames, detectPreambleCandidates, channelImpulseResponse, channelDelaySpread, reverbCheck, setSymbolCapture, setFrameDebug, setPreambleRepeats, setParityGroup, FILE_HASH, setFileHash, FileHasher, classifyInputLevel, FeedbackDetector, generateCalibrationTone, generateSweepTone, setCalibrationChirp, FRAME_BEACON, buildBeaconFrame, FRAME_MESSAGE, MAX_MESSAGE_BYTES, bandConfigError, MFSKModem, MFSK_MAX_BYTES, setEqualizer, DECODE_FAIL, rfft, irfft, StreamResampler, combineMRC, setSubcarrierMask, setLinkSeed, initConstellation, applyAGC, bandpass };
 }
@@ -0,0 +1,85 @@
+// cli.js send and receive through main(), with real files and WAVs in a
+// temporary directory: node --test
+const test = require('node:test');
+const assert = require('node:assert/strict');
+const fs = require('fs');
+const os = require('os');
+const path = require('path');
+const M = require('../modem.js');
+const { main } = require('../cli.js');
+
+// main() with console output captured instead of printed
+function run(...argv) {
+    const out = [], log = console.log, error = console.error;
+    console.log = (...a) => out.push(a.join(' '));
+    console.error = (...a) => out.push(a.join(' '));
+    try { return { code: main(argv), out: out.join('\n') }; } finally { console.log = log; console.error = error; }
+}
+
+function tempDir() {
+    return fs.mkdtempSync(path.join(os.tmpdir(), 'audio-modem-'));
+}
+
+function writeFile(dir, name, n) {
+    const data = Buffer.alloc(n);
+    for (let i = 0; i < n; i++) data[i] = (i * 31 + 7) & 0xFF;
+    fs.writeFileSync(path.join(dir, name), data);
+    return data;
+}
+
+test('send then receive round-trips a file through a WAV (2085)', () => {
+    const dir = tempDir();
+    try {
+        for (const [name, size, mode] of [['small.bin', 1000, 'QPSK'], ['large.bin', 40000, '16-QAM']]) {
+            const data = writeFile(dir, name, size);
+            const wav = path.join(dir, `${name}.wav`);
+            assert.equal(run('send', path.join(dir, name), wav, '--mode', mode).code, 0);
+            assert.equal(run('receive', wav, path.join(dir, 'out'), '--mode', mode).code, 0);
+            assert.deepEqual(fs.readFileSync(path.join(dir, 'out', name)), data);
+        }
+    } finally {
+        fs.rmSync(dir, { recursive: true, force: true });
+    }
+});
+
+test('a second receive of the same name does not overwrite the first (2085)', () => {
+    const dir = tempDir();
+    try {
+        const first = writeFile(dir, 'report.txt', 300);
+        const wav1 = path.join(dir, 'one.wav');
+        assert.equal(run('send', path.join(dir, 'report.txt'), wav1).code, 0);
+        const second = writeFile(dir, 'report.txt', 500);
+        const wav2 = path.join(dir, 'two.wav');
+        assert.equal(run('send', path.join(dir, 'report.txt'), wav2).code, 0);
+
+        const out = path.join(dir, 'out');
+        assert.equal(run('receive', wav1, out).code, 0);
+        const { out: printed } = run('receive', wav2, out);
+        assert.match(printed, /report \(1\)\.txt/);
+        assert.deepEqual(fs.readFileSync(path.join(out, 'report.txt')), first);
+        assert.deepEqual(fs.readFileSync(path.join(out, 'report (1).txt')), second);
+    } finally {
+        fs.rmSync(dir, { recursive: true, force: true });
+    }
+});
+
+test('--hash sends even a small file with its hash (2085)', () => {
+    const dir = tempDir();
+    try {
+        const data = writeFile(dir, 'tiny.bin', 200);
+        const wav = path.join(dir, 'tiny.wav');
+        assert.equal(run('send', path.join(dir, 'tiny.bin'), wav, '--hash', 'sha256').code, 0);
+        // A single frame has no metadata, so the hash needs the chunked form
+        const { samples } = M.decodeWAV(new Uint8Array(fs.readFileSync(wav)));
+        const file = M.assembleChunkFrames(M.decodeFrames(samples, 'QPSK', 1));
+        assert.equal(file.hashAlg, M.FILE_HASH.SHA256);
+        assert.equal(file.hashValid, true);
+        assert.equal(run('receive', wav, path.join(dir, 'out')).code, 0);
+        assert.deepEqual(fs.readFileSync(path.join(dir, 'out', 'tiny.bin')), data);
+        // MFSK has no chunk frames to carry one
+        assert.equal(run('send', path.join(dir, 'tiny.bin'), wav, '--mode', 'MFSK', '--hash', 'sha256').code, 2);
+    } finally {
+        fs.rmSync(dir, { recursive: true, force: true });
+        run('receive'); // leaves the file hash off for later tests (prints usage)
+    }
+});
@@ -0,0 +1,724 @@
+// Regression tests for the DSP core: node --test
+// Noise and payloads come from a seeded generator (see withSeed), so every
+// run sees the same signals and the thresholds below are not flaky.
+const test = require('node:test');
+const assert = require('node:assert/strict');
+const M = require('../modem.js');
+
+// Runs fn with Math.random replaced by a seeded generator (mulberry32);
+// measureBER draws its payload and noise from Math.random as well
+function withSeed(seed, fn) {
+    const random = Math.random;
+    let a = seed;
+    Math.random = () => {
+        a = (a + 0x6D2B79F5) | 0;
+        let t = Math.imul(a ^ (a >>> 15), 1 | a);
+        t = (t + Math.imul(t ^ (t >>> 7), 61 | t)) ^ t;
+        return ((t ^ (t >>> 14)) >>> 0) / 4294967296;
+    };
+    try { return fn(); } finally { Math.random = random; }
+}
+
+function randomBytes(n) {
+    const out = new Uint8Array(n);
+    for (let i = 0; i < n; i++) out[i] = Math.floor(Math.random() * 256);
+    return out;
+}
+
+// White Gaussian noise at snrDb below the mean power of the non-silent samples
+function addNoise(signal, snrDb) {
+    let power = 0, count = 0;
+    for (const v of signal) if (v !== 0) { power += v * v; count++; }
+    const sigma = Math.sqrt(power / count / Math.pow(10, snrDb / 10));
+    const out = Float32Array.from(signal);
+    for (let i = 0; i < out.length; i++) {
+        const u = Math.random() || 1e-12, v = Math.random();
+        out[i] += sigma * Math.sqrt(-2 * Math.log(u)) * Math.cos(2 * Math.PI * v);
+    }
+    return out;
+}
+
+function concat(...parts) {
+    const out = new Float32Array(parts.reduce((n, p) => n + p.length, 0));
+    let off = 0;
+    for (const p of parts) { out.set(p, off); off += p.length; }
+    return out;
+}
+
+// Direct path minus a 0.97 echo 36 samples later: |H| dips to 0.03 every
+// ~14 subcarriers, nulls that sweep across the pilots, inside the cyclic prefix
+function notchChannel(signal) {
+    const out = Float32Array.from(signal);
+    for (let i = 36; i < signal.length; i++) out[i] -= 0.97 * signal[i - 36];
+    return out;
+}
+
+// Standard config layout, for editing a frame in the frequency domain
+const STD = {
+    fftSize: 512, cpLen: 64, symbolLen: 576, subStart: 12, subEnd: 232,
+    pilots: [15, 29, 43, 57, 71, 85, 99, 113, 127, 141, 155, 169, 183, 197, 211, 225],
+};
+
+// Flips the given bits of the first frame's header,
+// [version:3][beacon:1][totalBits:20][subMask:16][CRC-8:8], on every
+// subcarrier that carries a copy. The header is BPSK, so negating a bin
+// flips its bit.
+function flipHeaderBits(signal, bits) {
+    const [first] = M.decodeFrames(signal, 'QPSK', 1);
+    const start = first.preambleIdx + 3 * STD.symbolLen; // past preamble and CE
+    const [re, im] = M.rfft(Float64Array.from(signal.subarray(start + STD.cpLen, start + STD.symbolLen)));
+    let bit = 0;
+    for (let k = STD.subStart; k <= STD.subEnd; k++) {
+        if (STD.pilots.includes(k)) continue;
+        if (bits.includes(bit++ % 48)) { re[k] = -re[k]; im[k] = -im[k]; }
+    }
+    const symbol = M.irfft(re, im);
+    const out = Float32Array.from(signal);
+    out.set(symbol.subarray(STD.fftSize - STD.cpLen), start);
+    out.set(symbol, start + STD.cpLen);
+    return out;
+}
+
+// Sample clock offset: the receiver runs ppm fast relative to the sender
+const clockOffset = ppm => signal => M.resample(signal, 1 + ppm * 1e-6, 1);
+
+test('MMSE beats zero-forcing on a channel with spectral nulls (2042)', () => {
+    const ber = equalizer => {
+        M.setEqualizer(equalizer);
+        // Same seed, so both equalizers see the same payload and noise
+        return withSeed(1, () => new M.Modem('standard', 'QAM16').measureBER({ snrDb: 25, numBits: 20000, channel: notchChannel }).ber);
+    };
+    try {
+        const zf = ber('zf'), mmse = ber('mmse');
+        assert.ok(mmse < zf, `MMSE BER ${mmse}, ZF BER ${zf}`);
+        assert.ok(mmse < 0.1, `MMSE BER ${mmse}`);
+    } finally {
+        M.setEqualizer('mmse');
+    }
+});
+
+test('a 300 ppm clock offset costs no bits, even in 16-QAM (2043)', () => {
+    withSeed(2, () => {
+        for (const modName of ['QPSK', 'QAM16']) {
+            for (const ppm of [300, -300]) {
+                const r = new M.Modem('standard', modName).measureBER({ snrDb: 25, numBits: 40000, channel: clockOffset(ppm) });
+                assert.equal(r.ber, 0, `${modName} at ${ppm} ppm`);
+            }
+        }
+    });
+});
+
+test('a re-sync symbol recovers timing after a dropped span (2045)', () => {
+    withSeed(3, () => {
+        const modem = new M.Modem('standard', 'QPSK');
+        const data = randomBytes(6000);
+        const signal = modem.encode(data, 'drop.bin');
+        // Lose 60 samples, far more than the cyclic prefix, mid-frame
+        const at = Math.floor(signal.length * 0.4), drop = 60;
+        const cut = concat(signal.subarray(0, at), signal.subarray(at + drop));
+        const result = modem.decode(cut);
+        assert.equal(result.error, 'CRC mismatch');
+        let firstBad = -1, lastBad = -1;
+        for (let i = 0; i < data.length; i++) {
+            if (result.data[i] === data[i]) continue;
+            if (firstBad < 0) firstBad = i;
+            lastBad = i;
+        }
+        assert.ok(firstBad > 0, 'bytes before the drop decode');
+        // The next re-sync symbol comes within ~1000 bytes of the drop
+        assert.ok(lastBad < data.length * 0.6, `errors stop at the next re-sync symbol (bytes ${firstBad}..${lastBad})`);
+    });
+});
+
+test('the Modem facade round-trips single frames and chunked files (2046)', () => {
+    withSeed(9, () => {
+        for (const [configName, modName] of [['standard', 'QPSK'], ['standard', 'QAM16'], ['acoustic', 'QPSK']]) {
+            const modem = new M.Modem(configName, modName);
+            const data = randomBytes(700);
+            assert.deepEqual(modem.decode(modem.encode(data, 'one.bin')), { data, fileName: 'one.bin' });
+            const file = modem.encodeFile(data, 'many.bin', 256);
+            assert.deepEqual(modem.decodeFile(file), { data, fileName: 'many.bin' });
+            // decode names what it found instead of misreading a chunked recording
+            assert.equal(modem.decode(file).reason, M.DECODE_FAIL.FRAME_TYPE);
+        }
+    });
+});
+
+test('frames of any length decode from their own header (2047)', () => {
+    withSeed(10, () => {
+        const modem = new M.Modem('standard', 'QPSK');
+        for (const n of [1, 90, 2500]) {
+            const data = randomBytes(n);
+            assert.deepEqual(modem.decode(modem.encode(data, 'len.bin')).data, data, `${n} bytes`);
+        }
+        // Back-to-back chunk frames of different lengths, each read to its own end
+        const frames = M.decodeFrames(modem.encodeFile(randomBytes(700), 'len.bin', 256), 'QPSK', 1);
+        assert.deepEqual(frames.slice(1).map(f => f.crcValid && f.dataLen), [256, 256, 188]);
+    });
+});
+
+test('a corrupted frame length is caught by the header CRC (2047)', () => {
+    withSeed(11, () => {
+        const modem = new M.Modem('standard', 'QPSK');
+        const signal = modem.encode(randomBytes(300), 'len.bin');
+        const damaged = flipHeaderBits(signal, [22]); // totalBits ± 2
+        assert.equal(modem.decode(damaged).error, 'Frame header CRC mismatch');
+        assert.deepEqual(M.decodeFrames(damaged, 'QPSK', 1), []);
+    });
+});
+
+// Frequency from the upward zero crossings, interpolated between samples
+function toneFrequency(x, rate) {
+    let first = -1, last = -1, cycles = -1;
+    for (let i = 1; i < x.length; i++) {
+        if (x[i - 1] >= 0 || x[i] < 0) continue;
+        const t = i - 1 - x[i - 1] / (x[i] - x[i - 1]);
+        if (first < 0) first = t;
+        last = t;
+        cycles++;
+    }
+    return cycles * rate / (last - first);
+}
+
+// Feeds a 48 kHz device stream to a StreamResampler in blocks, as the app does
+function resampleLive(input, inRate, outRate) {
+    const r = new M.StreamResampler(inRate, outRate);
+    const parts = [];
+    for (let i = 0; i < input.length; i += 1000) parts.push(r.process(input.subarray(i, i + 1000)));
+    return concat(...parts);
+}
+
+test('48 kHz input resampled to 44.1 kHz keeps its frequency (2055)', () => {
+    for (const f of [440, 3000, 9000, 18000]) {
+        const tone = new Float32Array(48000);
+        for (let i = 0; i < tone.length; i++) tone[i] = 0.5 * Math.sin(2 * Math.PI * f * i / 48000);
+        // Skip the filter's start-up. Played at the wrong rate the tone
+        // would read 8.8% high; linear zero-crossing interpolation is good
+        // to well under 0.5 Hz even at 18 kHz.
+        const out = resampleLive(tone, 48000, 44100).subarray(100);
+        const measured = toneFrequency(out, 44100);
+        assert.ok(Math.abs(measured - f) < 0.5, `${f} Hz read as ${measured}`);
+    }
+    // Phase is kept too: a frame played at 48 kHz still correlates and decodes
+    withSeed(12, () => {
+        const modem = new M.Modem('standard', 'QAM16');
+        const data = randomBytes(400);
+        const device = M.resample(modem.encode(data, 'rate.bin'), 44100, 48000);
+        assert.deepEqual(modem.decode(resampleLive(device, 48000, 44100)).data, data);
+    });
+});
+
+test('maximal-ratio combining beats either microphone alone (2056)', () => {
+    // SNR of y against the clean signal s, after the best scaling of s
+    const snrDb = (y, s) => {
+        let ys = 0, ss = 0, yy = 0;
+        for (let i = 0; i < s.length; i++) { ys += y[i] * s[i]; ss += s[i] * s[i]; yy += y[i] * y[i]; }
+        return 10 * Math.log10(ys * ys / (ss * yy - ys * ys));
+    };
+    const modem = new M.Modem('standard', 'QPSK');
+    let decoded = 0;
+    for (let seed = 1; seed <= 8; seed++) {
+        withSeed(seed, () => {
+            const s = modem.encodeFile(randomBytes(512), 'mrc.bin', 256);
+            // The second mic is wired in reverse and a little further away
+            const a = addNoise(s, 12.5);
+            const b = addNoise(s.map(v => -0.9 * v), 12.5 + 20 * Math.log10(0.9));
+            const y = M.combineMRC([a, b]);
+            assert.ok(snrDb(y, s) > Math.max(snrDb(a, s), snrDb(b, s)) + 1.5, `seed ${seed}`);
+            for (const x of [a, b]) assert.ok(modem.decodeFile(x).error, `seed ${seed}: one mic alone fails`);
+            if (!modem.decodeFile(y).error) decoded++;
+        });
+    }
+    assert.ok(decoded >= 6, `${decoded}/8 combined recordings decoded`);
+});
+
+// Removes f0..f1 Hz from a recording outright, like a notch filter in the
+// audio path
+function notchBand(signal, f0, f1, rate = 44100) {
+    let n = 1;
+    while (n < signal.length) n *= 2;
+    const x = new Float64Array(n);
+    x.set(signal);
+    const [re, im] = M.rfft(x);
+    for (let k = Math.floor(f0 * n / rate); k <= Math.ceil(f1 * n / rate); k++) { re[k] = 0; im[k] = 0; }
+    return Float32Array.from(M.irfft(re, im).subarray(0, signal.length));
+}
+
+test('masking a notched band recovers a file that fails unmasked (2060)', () => {
+    withSeed(13, () => {
+        const modem = new M.Modem('standard', 'QPSK');
+        const data = randomBytes(1500);
+        const notched = notchBand(modem.encodeFile(data, 'notch.bin', 512), 4000, 5500);
+        assert.ok(modem.decodeFile(notched).error, 'unmasked transfer fails');
+        // The frame headers survive the notch and carry the receiver's suggestion
+        const { suggestedMask } = M.decodeFrames(notched, 'QPSK', 1).find(f => f.suggestedMask);
+        assert.equal(suggestedMask, 0xFFF3, 'groups 2 and 3 (~3.4-5.6 kHz) are dropped');
+        try {
+            M.setSubcarrierMask(suggestedMask);
+            const masked = notchBand(modem.encodeFile(data, 'notch.bin', 512), 4000, 5500);
+            assert.deepEqual(modem.decodeFile(masked).data, data);
+        } finally {
+            M.setSubcarrierMask(0xFFFF);
+        }
+    });
+});
+
+test('a frame sent on one link seed is not decoded on another (2061)', () => {
+    withSeed(14, () => {
+        const modem = new M.Modem('standard', 'QPSK');
+        const a = randomBytes(300), b = randomBytes(300);
+        try {
+            M.setLinkSeed(1);
+            const single = modem.encode(a, 'a.bin');
+            const fileA = modem.encodeFile(a, 'a.bin', 256);
+            M.setLinkSeed(2);
+            const fileB = modem.encodeFile(b, 'b.bin', 256);
+            // Seed 2's CE symbol does not fit seed 1's frame, so its header is rejected
+            assert.equal(modem.decode(single).error, 'Frame header CRC mismatch');
+            assert.deepEqual(M.decodeFrames(fileA, 'QPSK', 1), []);
+            // Two links on one channel each hear only their own file
+            const both = concat(fileA, fileB);
+            assert.deepEqual(modem.decodeFile(both), { data: b, fileName: 'b.bin' });
+            M.setLinkSeed(1);
+            assert.deepEqual(modem.decodeFile(both), { data: a, fileName: 'a.bin' });
+        } finally {
+            M.setLinkSeed(0);
+        }
+    });
+});
+
+test('rfft matches a naive DFT and irfft inverts it (2065)', () => {
+    withSeed(4, () => {
+        for (const n of [16, 512]) {
+            const x = new Float64Array(n);
+            for (let i = 0; i < n; i++) x[i] = Math.random() * 2 - 1;
+            const [re, im] = M.rfft(x);
+            for (let k = 0; k < n; k++) {
+                let dRe = 0, dIm = 0;
+                for (let t = 0; t < n; t++) {
+                    dRe += x[t] * Math.cos(-2 * Math.PI * k * t / n);
+                    dIm += x[t] * Math.sin(-2 * Math.PI * k * t / n);
+                }
+                assert.ok(Math.abs(re[k] - dRe) < 1e-9 && Math.abs(im[k] - dIm) < 1e-9, `n=${n} bin ${k}`);
+            }
+            const back = M.irfft(re, im);
+            for (let i = 0; i < n; i++) assert.ok(Math.abs(back[i] - x[i]) < 1e-12);
+        }
+    });
+});
+
+test('a received file never takes the name of an earlier one (2071)', () => {
+    const saved = new Map();
+    const receive = (name, data) => {
+        const as = M.uniqueFileName(name, n => saved.has(n));
+        saved.set(as, data);
+        return as;
+    };
+    assert.equal(receive('report.pdf', 'v1'), 'report.pdf');
+    assert.equal(receive('report.pdf', 'v2'), 'report (1).pdf');
+    // A file that already carries the suffix takes the next free number
+    assert.equal(receive('report (1).pdf', 'v3'), 'report (1) (1).pdf');
+    assert.equal(receive('report.pdf', 'v4'), 'report (2).pdf');
+    assert.equal(saved.get('report.pdf'), 'v1');
+    assert.equal(saved.get('report (1).pdf'), 'v2');
+    assert.equal(receive('.profile', 'v5'), '.profile');
+    assert.equal(receive('.profile', 'v6'), '.profile (1)');
+});
+
+test('a registered 8-point constellation round-trips through the modem (2074)', () => {
+    // Gray-labelled 8-PSK: neighbouring phases differ in one bit
+    const points = [];
+    for (let g = 0; g < 8; g++) {
+        const angle = Math.PI / 8 + 2 * Math.PI * g / 8;
+        points[g ^ (g >> 1)] = [Math.cos(angle), Math.sin(angle)];
+    }
+    assert.equal(M.registerConstellation('8PSK', points, 16).bps, 3);
+    assert.ok(M.registerConstellation('QPSK', points).error, 'built-ins stay put');
+    withSeed(15, () => {
+        const modem = new M.Modem('standard', '8PSK');
+        assert.equal(modem.modName, '8PSK');
+        // 3 bits per point: symbols and frames end off byte boundaries
+        const data = randomBytes(1001);
+        assert.deepEqual(modem.decode(modem.encode(data, '8psk.bin')).data, data);
+        assert.deepEqual(modem.decodeFile(modem.encodeFile(data, '8psk.bin', 256)).data, data);
+        assert.equal(modem.measureBER({ snrDb: 25, numBits: 20000 }).ber, 0);
+    });
+});
+
+test('nearest constellation neighbours differ in one bit (2075)', () => {
+    for (const name of ['QPSK', 'QAM16']) {
+        const points = M.initConstellation(name).points;
+        let dMin = Infinity;
+        for (let i = 0; i < points.length; i++) {
+            for (let j = i + 1; j < points.length; j++) {
+                dMin = Math.min(dMin, Math.hypot(points[i][0] - points[j][0], points[i][1] - points[j][1]));
+            }
+        }
+        for (let i = 0; i < points.length; i++) {
+            for (let j = i + 1; j < points.length; j++) {
+                const d = Math.hypot(points[i][0] - points[j][0], points[i][1] - points[j][1]);
+                if (d > dMin * 1.001) continue;
+                let diff = i ^ j, bits = 0;
+                for (; diff; diff &= diff - 1) bits++;
+                assert.equal(bits, 1, `${name} points ${i} and ${j}`);
+            }
+        }
+    }
+});
+
+test('the AGC holds a fading, stepping signal at a steady level (2079)', () => {
+    const rate = 44100;
+    // 1 s of near-silence, 1 s at 0.01, a step to 0.3, then a 20 dB fade over 4 s
+    const amplitude = t => (t < 1 ? 0 : t < 2 ? 0.01 : t < 3 ? 0.3 : 0.3 * Math.pow(10, -(t - 3) / 4));
+    const x = new Float32Array(7 * rate);
+    for (let i = 0; i < x.length; i++) {
+        x[i] = amplitude(i / rate) * Math.sin(2 * Math.PI * 2000 * i / rate) + 1e-5 * Math.sin(2 * Math.PI * 50 * i / rate);
+    }
+    const rms = (a, start, end) => {
+        let p = 0;
+        for (let i = start; i < end; i++) p += a[i] * a[i];
+        return Math.sqrt(p / (end - start));
+    };
+    // Quarter-second windows over the signal, each past its first 50 ms of attack
+    const spread = a => {
+        const levels = [];
+        for (let s = rate; s < a.length; s += rate / 4) levels.push(rms(a, s + rate / 20, s + rate / 4));
+        return Math.max(...levels) / Math.min(...levels);
+    };
+    const y = M.applyAGC(x);
+    assert.ok(spread(y) < 1.25, `AGC level spread ${spread(y)}`);
+    assert.ok(spread(x) > 10, 'one global gain keeps the 30x spread of the input');
+    assert.ok(rms(y, 0, rate) < 0.02, 'silence is not pumped up to full scale');
+});
+
+test('the receive band-pass removes hum and keeps the data band intact (2080)', () => {
+    const rate = 44100;
+    const tone = (f, n = rate) => Float32Array.from({ length: n }, (_, i) => Math.sin(2 * Math.PI * f * i / rate));
+    const rms = a => Math.sqrt(a.reduce((p, v) => p + v * v, 0) / a.length);
+    const middle = a => a.subarray(5000, a.length - 5000); // away from the edges
+    for (const f of [50, 100, 21500]) {
+        const attenuation = 20 * Math.log10(rms(middle(M.bandpass(tone(f)))));
+        assert.ok(attenuation < -60, `${f} Hz at ${attenuation.toFixed(1)} dB`);
+    }
+    // In band, sample for sample: no gain change and no delay or phase shift
+    for (const f of [1500, 5000, 15000]) {
+        const x = tone(f), y = M.bandpass(x);
+        let worst = 0;
+        for (let i = 5000; i < x.length - 5000; i++) worst = Math.max(worst, Math.abs(y[i] - x[i]));
+        assert.ok(worst < 1e-3, `${f} Hz off by ${worst}`);
+    }
+    // A recording shorter than the filter delay keeps its length
+    assert.equal(M.bandpass(tone(5000, 300)).length, 300);
+    // A frame under mains hum far louder than itself still decodes
+    withSeed(16, () => {
+        const modem = new M.Modem('standard', 'QAM16');
+        const data = randomBytes(500);
+        const signal = modem.encode(data, 'hum.bin');
+        const hum = tone(60, signal.length);
+        const noisy = signal.map((v, i) => v + 5 * hum[i]);
+        assert.deepEqual(modem.decode(noisy).data, data);
+    });
+});
+
+test('back-to-back frames in one capture are all recovered (2099)', () => {
+    withSeed(11, () => {
+        const modem = new M.Modem('standard', 'QPSK');
+        const data = randomBytes(600);
+        const signal = modem.encodeFile(data, 'three.bin', 200);
+        const frames = M.decodeFrames(signal, 'QPSK', 1).slice(1); // after the metadata
+        assert.deepEqual(frames.map(f => f.crcValid && f.seqNum), [0, 1, 2]);
+        for (const f of frames) assert.deepEqual(f.data, data.subarray(f.seqNum * 200, f.seqNum * 200 + 200));
+        // A capture cut inside the last frame keeps the others and flags the tail
+        const cut = M.decodeFrames(signal.subarray(0, frames[2].preambleIdx + 2000), 'QPSK', 1);
+        assert.deepEqual(cut.slice(1, 3).map(f => f.crcValid && f.seqNum), [0, 1]);
+        assert.equal(cut[3].incomplete, true);
+        assert.equal(cut[3].reason, M.DECODE_FAIL.TRUNCATED);
+    });
+});
+
+test('encodeFile takes chunk sizes the receivers accept, short last chunk included (2100)', () => {
+    withSeed(12, () => {
+        const modem = new M.Modem('standard', 'QPSK');
+        const data = randomBytes(150);
+        for (const size of [0, 63, 4097, 1.5]) assert.ok(modem.encodeFile(data, 'size.bin', size).error, `${size}`);
+        const signal = modem.encodeFile(data, 'size.bin', 64);
+        assert.deepEqual(M.decodeFrames(signal, 'QPSK', 1).slice(1).map(f => f.dataLen), [64, 64, 22]);
+        assert.deepEqual(modem.decodeFile(signal), { data, fileName: 'size.bin' });
+    });
+});
+
+// Channel estimate H(k) of taps { delay: gain } at the standard FFT size.
+// The impulse-response functions read the current config; encoding any
+// standard frame sets it.
+function tapsResponse(taps) {
+    new M.Modem('standard', 'QPSK').encode(new Uint8Array(1), 'cfg');
+    const h = new Float64Array(STD.fftSize);
+    for (const [delay, gain] of Object.entries(taps)) h[delay] = gain;
+    return M.rfft(h);
+}
+
+test('the impulse response recovers a two-tap channel (2101)', () => {
+    const [re, im] = tapsResponse({ 0: 1, 20: 0.5 });
+    // Unestimated bins are interpolated rather than ringing through the response
+    for (const k of STD.pilots) { re[k] = 0; im[k] = 0; }
+    const impulse = M.channelImpulseResponse(re, im);
+    assert.ok(Math.abs(impulse[0] - 1) < 0.02, `${impulse[0]}`);
+    assert.ok(Math.abs(impulse[20] - 0.5) < 0.02, `${impulse[20]}`);
+    // Away from the taps only the window's sidelobes remain
+    for (let i = 0; i < STD.fftSize; i++) {
+        const far = Math.min(Math.abs(i), Math.abs(i - 20), STD.fftSize - i) > 4;
+        if (far) assert.ok(impulse[i] < 0.1, `${i}: ${impulse[i]}`);
+    }
+});
+
+test('an echo reaching the end of the cyclic prefix raises the reverb warning (2102)', () => {
+    const short = M.reverbCheck(...tapsResponse({ 0: 1, 10: 0.5 }));
+    assert.equal(short.reverbWarning, false);
+    // 40 samples on, the echo nearly reaches the FFT window (48 into the 64-sample CP)
+    const long = M.reverbCheck(...tapsResponse({ 0: 1, 40: 0.5 }));
+    assert.equal(long.reverbWarning, true);
+    assert.ok(long.delaySpread.maxExcess >= 40, `${long.delaySpread.maxExcess}`);
+});
+
+test('two copies that fail alone decode when soft-combined (2103)', () => {
+    // Near the QPSK threshold a single copy of a chunk never passes its CRC;
+    // Chase combining gains ~3 dB, enough for most pairs
+    const modem = new M.Modem('standard', 'QPSK');
+    let combined = 0;
+    for (let seed = 1; seed <= 8; seed++) {
+        withSeed(seed, () => {
+            const data = randomBytes(256);
+            const signal = modem.encodeFile(data, 'twice.bin', 256);
+            const a = addNoise(signal, 12.5), b = addNoise(signal, 12.5);
+            for (const copy of [a, b]) assert.ok(modem.decodeFile(copy).error, `seed ${seed}: one copy alone fails`);
+            const result = modem.decodeFile(concat(a, b));
+            if (!result.error) {
+                assert.deepEqual(result.data, data);
+                combined++;
+            }
+        });
+    }
+    assert.ok(combined >= 5, `${combined}/8 pairs decoded`);
+});
+
+test('a frame from an unknown protocol version is refused, not misread (2104)', () => {
+    withSeed(13, () => {
+        const modem = new M.Modem('standard', 'QPSK');
+        const signal = modem.encode(randomBytes(300), 'v.bin');
+        // Version 4 with a matching CRC-8: the CRC is linear with zero init, so
+        // flipping bit 0 takes flipping the bits of crc8([0x80, 0, 0, 0, 0]) too
+        const newer = flipHeaderBits(signal, [0, 40, 43, 45, 46, 47]);
+        const [frame] = M.decodeFrames(newer, 'QPSK', 1);
+        assert.equal(frame.reason, M.DECODE_FAIL.VERSION);
+        assert.equal(frame.version, 4);
+        assert.equal(modem.decode(newer).reason, M.DECODE_FAIL.VERSION);
+    });
+});
+
+test('16-QAM is error-free on a clean channel (2107)', () => {
+    withSeed(5, () => {
+        const r = new M.Modem('standard', 'QAM16').measureBER({ numBits: 200000 });
+        assert.equal(r.ber, 0);
+    });
+});
+
+test('XOR parity rebuilds a lost chunk (2111)', () => {
+    M.setParityGroup(4);
+    try {
+        withSeed(6, () => {
+            const modem = new M.Modem('standard', 'QPSK');
+            const data = randomBytes(900); // 4 chunks, the last one short
+            const signal = modem.encodeFile(data, 'parity.bin', 256);
+            const frames = M.decodeFrames(signal, 'QPSK', 1);
+            assert.equal(frames.length, 6); // metadata, 4 chunks, parity
+            // Silence the second chunk's frame up to the next preamble
+            signal.fill(0, frames[2].preambleIdx, frames[3].preambleIdx - 1000);
+            const result = modem.decodeFile(signal);
+            assert.ok(!result.error, result.error);
+            assert.deepEqual(result.data, data);
+        });
+    } finally {
+        M.setParityGroup(0);
+    }
+});
+
+test('SHA-256 matches the FIPS 180-2 vectors (2113)', () => {
+    const hex = bytes => Array.from(bytes, b => b.toString(16).padStart(2, '0')).join('');
+    const sha256 = (...parts) => {
+        const h = new M.FileHasher(M.FILE_HASH.SHA256);
+        for (const p of parts) h.update(new TextEncoder().encode(p));
+        return hex(h.digest());
+    };
+    assert.equal(sha256(''), 'e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855');
+    assert.equal(sha256('abc'), 'ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad');
+    const msg = 'abcdbcdecdefdefgefghfghighijhijkijkljklmklmnlmnomnopnopq';
+    const digest = '248d6a61d20638b8e5c026930c3e6039a33ce45964ff2167f6ecedd419db06c1';
+    assert.equal(sha256(msg), digest);
+    // Split across updates at an odd boundary
+    assert.equal(sha256(msg.slice(0, 7), msg.slice(7)), digest);
+    // digest() leaves the state alone: it repeats, and more data carries on the stream
+    const h = new M.FileHasher(M.FILE_HASH.SHA256).update(new TextEncoder().encode(msg.slice(0, 20)));
+    const partial = hex(h.digest());
+    assert.equal(hex(h.digest()), partial);
+    assert.equal(hex(h.update(new TextEncoder().encode(msg.slice(20))).digest()), digest);
+});
+
+test('input levels are classified low, good, loud and clipping (2118)', () => {
+    // One second of a 1 kHz tone at the given amplitude, optionally hard-clipped at ±1
+    const tone = (amp, gain = 1) => Float32Array.from({ length: 44100 },
+        (_, i) => Math.max(-1, Math.min(1, gain * amp * Math.sin(2 * Math.PI * 1000 * i / 44100))));
+    assert.equal(M.classifyInputLevel(tone(0.003)).level, 'low');
+    assert.equal(M.classifyInputLevel(tone(0.3)).level, 'good');
+    // Loud but intact is not clipping: no sample reaches the rails
+    assert.equal(M.classifyInputLevel(tone(0.95)).level, 'loud');
+    const clipped = M.classifyInputLevel(tone(0.5, 3));
+    assert.equal(clipped.level, 'clipping');
+    assert.ok(clipped.clippedSamples > 1000, `${clipped.clippedSamples}`);
+    // Nor is a single full-scale click
+    const click = tone(0.3);
+    click[1000] = 1;
+    assert.notEqual(M.classifyInputLevel(click).level, 'clipping');
+});
+
+test('beacons mixed with a file are told apart by the header flag (2122)', () => {
+    withSeed(8, () => {
+        const modem = new M.Modem('standard', 'QPSK');
+        const data = randomBytes(500);
+        const file = modem.encodeFile(data, 'mixed.bin', 256);
+        const beacon = M.buildBeaconFrame(0xA1B2C3D4, 7);
+        const frames = M.decodeFrames(concat(beacon, file, beacon), 'QPSK', 1);
+        const beacons = frames.filter(f => f.frameType === M.FRAME_BEACON && f.crcValid);
+        assert.equal(beacons.length, 2);
+        assert.equal(beacons[0].nodeId, 0xA1B2C3D4);
+        assert.equal(beacons[0].counter, 7);
+        assert.deepEqual(M.assembleChunkFrames(frames).data, data);
+    });
+});
+
+test('MFSK carries a short message through noise that sinks OFDM (2124)', () => {
+    const mfsk = new M.MFSKModem(), ofdm = new M.Modem('acoustic', 'BPSK');
+    const data = new TextEncoder().encode('PING 42 ready');
+    const tones = mfsk.encode(data, 'ctl');
+    assert.deepEqual(mfsk.decode(tones), { data, fileName: 'ctl' });
+    // At -6 dB acoustic BPSK loses sync or its CRC; MFSK puts all of its
+    // power into one tone at a time
+    const frame = ofdm.encode(data, 'ctl');
+    for (let seed = 1; seed <= 3; seed++) {
+        withSeed(seed, () => {
+            assert.ok(ofdm.decode(addNoise(frame, -6)).error, `seed ${seed}: OFDM`);
+            assert.deepEqual(mfsk.decode(addNoise(tones, -6)).data, data, `seed ${seed}: MFSK`);
+        });
+    }
+});
+
+test('a decoy that out-scores the preamble does not stop the decode (2127)', () => {
+    withSeed(3, () => {
+        const modem = new M.Modem('standard', 'QPSK');
+        const data = randomBytes(300);
+        const frame = modem.encode(data, 'real.bin');
+        // A block repeated four times is a perfect, flat-topped Schmidl-Cox
+        // plateau, ahead of the frame and louder than its preamble
+        const block = Float32Array.from({ length: 256 }, () => (Math.random() * 2 - 1) * 0.7);
+        const decoy = concat(new Float32Array(1000), block, block, block, block, new Float32Array(1000));
+        const signal = concat(decoy, frame);
+        const [best] = M.detectPreambleCandidates(signal);
+        assert.ok(best < decoy.length, `best candidate ${best} is the decoy`);
+        assert.ok(modem.decode(decoy).error);
+        // The next candidates are tried once the best one fails
+        assert.deepEqual(modem.decode(signal), { data, fileName: 'real.bin' });
+    });
+});
+
+test('dataRate matches the rate worked out by hand (2135)', () => {
+    const { rawBitRate, netBitRate } = new M.Modem('standard', 'QPSK').dataRate();
+    // 221 subcarriers less 16 pilots, 2 bits each, per 576-sample symbol
+    assert.equal(rawBitRate, 205 * 2 * 44100 / 576);
+    // A 2048-byte chunk and its 11 bytes of framing: 16472 bits in
+    // ceil(16472 / 410) = 41 data symbols, no re-sync. With the preamble,
+    // its second symbol, CE and one header symbol that is 45 symbols, plus
+    // 50 ms of gap and 20 ms of tail: 25920 + 2205 + 882 samples
+    const frame = 45 * 576 + 2205 + 882;
+    assert.ok(Math.abs(netBitRate - 2048 * 8 * 44100 / frame) < 1e-9, `${netBitRate}`);
+    // A parity frame, one byte longer, after every 4 chunks
+    M.setParityGroup(4);
+    try {
+        const { netBitRate: withParity } = new M.Modem('standard', 'QPSK').dataRate();
+        assert.ok(Math.abs(withParity - 2048 * 8 * 44100 / (frame * 1.25)) < 1e-9, `${withParity}`);
+    } finally {
+        M.setParityGroup(0);
+    }
+});
+
+test('FFT scaling: a unit sine reads n/2 in its bin (2137)', () => {
+    const n = 512, k = 37;
+    const x = new Float64Array(n);
+    for (let i = 0; i < n; i++) x[i] = Math.cos(2 * Math.PI * k * i / n);
+    const [re, im] = M.rfft(x);
+    assert.ok(Math.abs(Math.hypot(re[k], im[k]) - n / 2) < 1e-9);
+    assert.ok(Math.abs(Math.hypot(re[k + 1], im[k + 1])) < 1e-9);
+    // irfft divides by n, so a round trip keeps unit amplitude
+    const back = M.irfft(re, im);
+    for (let i = 0; i < n; i++) assert.ok(Math.abs(back[i] - x[i]) < 1e-12);
+});
+
+test('repeated preambles are found where a single one is not (2142)', () => {
+    try {
+        for (const repeats of [1, 4]) {
+            M.setPreambleRepeats(repeats);
+            let found = 0;
+            for (let seed = 1; seed <= 4; seed++) {
+                withSeed(seed, () => {
+                    const modem = new M.Modem('standard', 'QPSK');
+                    const signal = modem.encodeFile(randomBytes(64), 'weak.bin', 64);
+                    if (M.decodeFrames(addNoise(signal, 3), 'QPSK', 1).length) found++;
+                });
+            }
+            assert.equal(found, repeats === 1 ? 0 : 4, `${repeats} preamble copies`);
+        }
+        // A clean file round-trips with every repeat count
+        for (const repeats of [2, 3]) {
+            M.setPreambleRepeats(repeats);
+            withSeed(7, () => {
+                const modem = new M.Modem('standard', 'QPSK');
+                const data = randomBytes(1000);
+                assert.deepEqual(modem.decodeFile(modem.encodeFile(data, 'r.bin', 256)).data, data);
+            });
+        }
+    } finally {
+        M.setPreambleRepeats(1);
+    }
+});
+
+test('a message frame round-trips with its content type (2143)', () => {
+    const modem = new M.Modem('standard', 'QPSK');
+    const body = new TextEncoder().encode('{"cmd":"ping"}');
+    const { signal } = modem.encodeMessage(body, 'application/json');
+    const result = modem.decodeMessage(signal);
+    assert.deepEqual(result, { data: body, contentType: 'application/json' });
+    // A message is not a file, and a file holds no message
+    assert.ok(modem.decodeFile(signal).error);
+    assert.ok(modem.decodeMessage(modem.encodeFile(body, 'f.json', 64)).error);
+    // Data and content type share one limit, so the frame fits a streaming receiver
+    const type = 'text/plain';
+    assert.ok(modem.encodeMessage(new Uint8Array(M.MAX_MESSAGE_BYTES - type.length), type).signal);
+    assert.ok(modem.encodeMessage(new Uint8Array(M.MAX_MESSAGE_BYTES - type.length + 1), type).error);
+});
+
+test('a steady tone is not a howl but a rising one is (2145)', () => {
+    const block = 1024;
+    const feed = (detector, signal) => {
+        for (let i = 0; i < signal.length; i += block) detector.process(signal.subarray(i, i + block));
+        return detector.howl;
+    };
+
+    assert.equal(feed(new M.FeedbackDetector(), M.generateCalibrationTone(1000, 3)), null);
+
+    const rising = new Float32Array(3 * 44100);
+    for (let i = 0; i < rising.length; i++) {
+        const amplitude = 0.01 * Math.pow(10, 2 * i / rising.length); // -40 → 0 dBFS
+        rising[i] = amplitude * Math.sin(2 * Math.PI * 1500 * i / 44100);
+    }
+    const howl = feed(new M.FeedbackDetector(), rising);
+    assert.ok(howl, 'howl reported');
+    assert.ok(Math.abs(howl.freq - 1500) < 30, `howl at ${howl.freq} Hz`);
+});